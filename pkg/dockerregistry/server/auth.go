@@ -21,6 +21,7 @@ import (
 	"github.com/openshift/image-registry/pkg/dockerregistry/server/audit"
 	"github.com/openshift/image-registry/pkg/dockerregistry/server/client"
 	"github.com/openshift/image-registry/pkg/dockerregistry/server/configuration"
+	"github.com/openshift/image-registry/pkg/imagestream"
 )
 
 type deferredErrors map[string]error
@@ -254,7 +255,12 @@ func (ac *AccessController) Authorized(ctx context.Context, accessRecords ...reg
 				return nil, ac.wrapErr(ctx, ErrUnsupportedAction)
 			}
 
-			if err := verifyImageStreamAccess(ctx, imageStreamNS, imageStreamName, verb, osClient); err != nil {
+			if access.Action == "pull" && len(bearerToken) == 0 {
+				err = ac.verifyAnonymousPull(ctx, imageStreamNS, imageStreamName)
+			} else {
+				err = verifyImageStreamAccess(ctx, imageStreamNS, imageStreamName, verb, osClient)
+			}
+			if err != nil {
 				if access.Action != "pull" {
 					return nil, ac.wrapErr(ctx, err)
 				}
@@ -472,6 +478,29 @@ func verifyImageStreamAccess(ctx context.Context, namespace, imageRepo, verb str
 	return verifyWithSAR(ctx, "imagestreams/layers", namespace, imageRepo, verb, c)
 }
 
+// verifyAnonymousPull checks whether anonymous users may pull from the image
+// stream namespace/name. The policy is owned by the image stream, see
+// ImageStream.AllowsAnonymousPull.
+func (ac *AccessController) verifyAnonymousPull(ctx context.Context, namespace, name string) error {
+	registryOSClient, err := ac.registryClient.Client()
+	if err != nil {
+		return err
+	}
+	allowed, rErr := imagestream.New(ctx, namespace, name, registryOSClient).AllowsAnonymousPull(ctx)
+	if rErr != nil {
+		dcontext.GetLogger(ctx).Errorf("OpenShift client error: %s", rErr)
+		if rErr.Code() == imagestream.ErrImageStreamForbiddenCode {
+			return ErrOpenShiftAccessDenied
+		}
+		return rErr
+	}
+	if !allowed {
+		dcontext.GetLogger(ctx).Errorf("OpenShift access denied: anonymous pulls from %s/%s are not allowed", namespace, name)
+		return ErrOpenShiftAccessDenied
+	}
+	return nil
+}
+
 func verifyImageSignatureAccess(ctx context.Context, namespace, imageRepo string, c client.SelfSubjectAccessReviewsNamespacer) error {
 	return verifyWithSAR(ctx, "imagesignatures", namespace, imageRepo, "create", c)
 }
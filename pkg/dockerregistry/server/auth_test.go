@@ -46,6 +46,13 @@ func sarResponse(ns string, allowed bool, reason string) *authorizationapi.SelfS
 	return resp
 }
 
+func lsarResponse(ns string, allowed bool, reason string) *authorizationapi.LocalSubjectAccessReview {
+	resp := &authorizationapi.LocalSubjectAccessReview{}
+	resp.Namespace = ns
+	resp.Status = authorizationapi.SubjectAccessReviewStatus{Allowed: allowed, Reason: reason}
+	return resp
+}
+
 // TestVerifyImageStreamAccess mocks openshift http request/response and
 // tests invalid/valid/scoped openshift tokens.
 func TestVerifyImageStreamAccess(t *testing.T) {
@@ -352,12 +359,31 @@ func TestAccessController(t *testing.T) {
 			}},
 			bearerToken: "anonymous",
 			openshiftResponses: []response{
-				{200, runtime.EncodeOrDie(codecs.LegacyCodec(authorizationapi.SchemeGroupVersion), sarResponse("foo", true, "authorized!"))},
+				{200, runtime.EncodeOrDie(codecs.LegacyCodec(authorizationapi.SchemeGroupVersion), lsarResponse("foo", true, "authorized!"))},
 			},
 			expectedError:     nil,
 			expectedChallenge: false,
 			expectedActions: []string{
-				"POST /apis/authorization.k8s.io/v1/selfsubjectaccessreviews (Authorization=)",
+				"POST /apis/authorization.k8s.io/v1/namespaces/foo/localsubjectaccessreviews (Authorization=)",
+			},
+		},
+		"anonymous pull denied": {
+			access: []auth.Access{{
+				Resource: auth.Resource{
+					Type: "repository",
+					Name: "foo/bar",
+				},
+				Action: "pull",
+			}},
+			bearerToken: "anonymous",
+			openshiftResponses: []response{
+				{200, runtime.EncodeOrDie(codecs.LegacyCodec(authorizationapi.SchemeGroupVersion), lsarResponse("foo", false, "not authorized!"))},
+			},
+			expectedError:     ErrOpenShiftAccessDenied,
+			expectedChallenge: true,
+			expectedHeaders:   http.Header{"Www-Authenticate": []string{`Basic realm=myrealm,error="access denied"`}},
+			expectedActions: []string{
+				"POST /apis/authorization.k8s.io/v1/namespaces/foo/localsubjectaccessreviews (Authorization=)",
 			},
 		},
 		"pruning": {
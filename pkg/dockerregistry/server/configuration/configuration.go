@@ -165,6 +165,10 @@ type Cache struct {
 type Quota struct {
 	Enabled  bool          `yaml:"enabled"`
 	CacheTTL time.Duration `yaml:"cachettl"`
+	// LimitRangeMaxAge, if positive, is the maximum age of cached limit
+	// ranges. Older limit ranges are fetched again even if they have not
+	// been evicted from the cache yet.
+	LimitRangeMaxAge time.Duration `yaml:"limitrangemaxage"`
 }
 
 type Pullthrough struct {
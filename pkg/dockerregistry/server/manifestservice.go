@@ -206,6 +206,10 @@ func (m *manifestService) Put(ctx context.Context, manifest distribution.Manifes
 		case imagestream.ErrImageStreamForbiddenCode:
 			dcontext.GetLogger(ctx).Errorf("manifestService.Put: imagestreammapping got access denied for image %s@%s: %v", m.imageStream.Reference(), image.Name, rErr)
 			return "", distribution.ErrAccessDenied
+		case imagestream.ErrImageStreamProvisionRetryFailedCode:
+			// The image stream exists now, so the push may be retried.
+			dcontext.GetLogger(ctx).Errorf("manifestService.Put: imagestreammapping failed after provisioning the image stream for image %s@%s: %v", m.imageStream.Reference(), image.Name, rErr)
+			return "", errcode.ErrorCodeUnavailable.WithDetail(fmt.Sprintf("unable to tag image in provisioned repository %s", m.imageStream.Reference()))
 		}
 		return "", rErr
 	}
@@ -9,6 +9,7 @@ import (
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/registry/api/errcode"
 	"github.com/opencontainers/go-digest"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -16,7 +17,9 @@ import (
 	"github.com/openshift/image-registry/pkg/dockerregistry/server/cache"
 	registryclient "github.com/openshift/image-registry/pkg/dockerregistry/server/client"
 	"github.com/openshift/image-registry/pkg/dockerregistry/server/metrics"
+	rerrors "github.com/openshift/image-registry/pkg/errors"
 	"github.com/openshift/image-registry/pkg/imagestream"
+	"github.com/openshift/image-registry/pkg/imagestream/fake"
 	"github.com/openshift/image-registry/pkg/testutil"
 )
 
@@ -393,3 +396,46 @@ func TestManifestServicePut(t *testing.T) {
 		})
 	}
 }
+
+func TestManifestServicePutProvisionRetryFailed(t *testing.T) {
+	ctx := context.Background()
+	ctx = testutil.WithTestLogger(ctx, t)
+
+	_, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+	bs := newTestBlobStore(nil, blobContents{
+		"testblob:1":   []byte("{}"),
+		"testconfig:2": []byte("{}"),
+	})
+	client := registryclient.NewFakeRegistryAPIClient(nil, imageClient)
+
+	imageStream := fake.NewFakeImageStream("user", "app")
+	imageStream.Stream = nil
+	imageStream.Errors["CreateImageStreamMapping"] = rerrors.NewError(imagestream.ErrImageStreamProvisionRetryFailedCode, "injected", nil)
+
+	ms := &manifestService{
+		serverAddr:       "localhost",
+		manifests:        newTestManifestService("user/app", nil),
+		blobStore:        bs,
+		registryOSClient: client,
+		imageStream:      imageStream,
+		acceptSchema2:    true,
+	}
+	osclient, err := registryclient.NewFakeRegistryClient(imageClient).Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := testutil.MakeSchema2Manifest(
+		distribution.Descriptor{Digest: "testconfig:2", Size: 2},
+		[]distribution.Descriptor{{Digest: "testblob:1", Size: 2}},
+	)
+	if err != nil {
+		t.Fatalf("could not make schema 2 manifest: %s", err)
+	}
+
+	putCtx := withUserClient(withAuthPerformed(ctx), osclient)
+	_, err = ms.Put(putCtx, manifest, distribution.WithTag("latest"))
+	if e, ok := err.(errcode.Error); !ok || e.Code != errcode.ErrorCodeUnavailable {
+		t.Errorf("got error %#v, want %s", err, errcode.ErrorCodeUnavailable)
+	}
+}
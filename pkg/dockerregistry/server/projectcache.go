@@ -15,7 +15,7 @@ type projectObjectListCache struct {
 	store cache.Store
 }
 
-var _ imagestream.ProjectObjectListStore = &projectObjectListCache{}
+var _ imagestream.TimestampedProjectObjectListStore = &projectObjectListCache{}
 
 // newProjectObjectListCache creates a cache to hold object list objects that will expire with the given ttl.
 func newProjectObjectListCache(ttl time.Duration) imagestream.ProjectObjectListStore {
@@ -33,30 +33,39 @@ func (c *projectObjectListCache) Add(namespace string, obj runtime.Object) error
 	no := &namespacedObject{
 		namespace: namespace,
 		object:    obj,
+		added:     time.Now(),
 	}
 	return c.store.Add(no)
 }
 
 // get retrieves a cached list object if present and not expired.
 func (c *projectObjectListCache) Get(namespace string) (runtime.Object, bool, error) {
+	obj, _, exists, err := c.GetWithTimestamp(namespace)
+	return obj, exists, err
+}
+
+// GetWithTimestamp is like Get, but it also returns the time when the object
+// has been added.
+func (c *projectObjectListCache) GetWithTimestamp(namespace string) (runtime.Object, time.Time, bool, error) {
 	entry, exists, err := c.store.GetByKey(namespace)
 	if err != nil {
-		return nil, exists, err
+		return nil, time.Time{}, exists, err
 	}
 	if !exists {
-		return nil, false, err
+		return nil, time.Time{}, false, err
 	}
 	no, ok := entry.(*namespacedObject)
 	if !ok {
-		return nil, false, fmt.Errorf("%T is not a namespaced object", entry)
+		return nil, time.Time{}, false, fmt.Errorf("%T is not a namespaced object", entry)
 	}
-	return no.object, true, nil
+	return no.object, no.added, true, nil
 }
 
 // namespacedObject is a container associating an object with a namespace.
 type namespacedObject struct {
 	namespace string
 	object    runtime.Object
+	added     time.Time
 }
 
 // metaProjectObjectListKeyFunc returns a key for given namespaced object. The key is object's namespace.
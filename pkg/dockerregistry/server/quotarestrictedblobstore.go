@@ -15,6 +15,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/docker/distribution"
 	dcontext "github.com/docker/distribution/context"
@@ -47,6 +48,7 @@ func newQuotaEnforcingConfig(ctx context.Context, quotaCfg *configuration.Quota)
 	return &quotaEnforcingConfig{
 		enforcementEnabled: true,
 		limitRanges:        newProjectObjectListCache(quotaCfg.CacheTTL),
+		limitRangeMaxAge:   quotaCfg.LimitRangeMaxAge,
 	}
 }
 
@@ -57,6 +59,8 @@ type quotaEnforcingConfig struct {
 	enforcementEnabled bool
 	// if set, enables caching of quota objects per project
 	limitRanges imagestream.ProjectObjectListStore
+	// if positive, cached limit ranges older than this are fetched again
+	limitRangeMaxAge time.Duration
 }
 
 // quotaRestrictedBlobStore wraps upstream blob store with a guard preventing big layers exceeding image quotas
@@ -128,7 +132,7 @@ func admitBlobWrite(ctx context.Context, repo *repository, size int64) error {
 		return nil
 	}
 
-	lrs, err := repo.imageStream.GetLimitRangeList(ctx, repo.app.quotaEnforcing.limitRanges)
+	lrs, err := repo.imageStream.GetLimitRangeList(ctx, repo.app.quotaEnforcing.limitRanges, repo.app.quotaEnforcing.limitRangeMaxAge)
 	if err != nil {
 		return err
 	}
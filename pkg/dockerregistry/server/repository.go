@@ -65,6 +65,10 @@ func (app *App) Repository(ctx context.Context, repo distribution.Repository, cr
 		return nil, nil, err
 	}
 
+	imageStream := imagestream.New(ctx, namespace, name, registryOSClient,
+		imagestream.WithDefaultKeyring(&lazyInstallCredentialsKeyring{ctx: ctx}),
+	)
+
 	r := &repository{
 		Repository: repo,
 
@@ -72,7 +76,7 @@ func (app *App) Repository(ctx context.Context, repo distribution.Repository, cr
 		app:        app,
 		crossmount: crossmount,
 
-		imageStream: imagestream.New(ctx, namespace, name, registryOSClient),
+		imageStream: imageStream,
 		cache:       cache.NewRepositoryDigest(app.cache),
 		icsp:        registryOSClient.ImageContentSourcePolicy(),
 	}
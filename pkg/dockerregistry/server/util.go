@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/docker/distribution"
 	dcontext "github.com/docker/distribution/context"
@@ -46,15 +47,9 @@ func getNamespaceName(resourceName string) (string, string, error) {
 	return ns, name, nil
 }
 
-// getImportContext loads secrets and returns a context for getting
-// distribution clients to remote repositories.
-func getImportContext(ctx context.Context, ref *reference.DockerImageReference, secrets []corev1.Secret, m metrics.Pullthrough, icsp operatorv1alpha1.ImageContentSourcePolicyInterface) (registryclient.RepositoryRetriever, error) {
-	req, err := dcontext.GetRequest(ctx)
-	if err != nil {
-		dcontext.GetLogger(ctx).Errorf("unable to get request from context: %v", err)
-		return nil, err
-	}
-
+// newInstallCredentialsKeyring returns a keyring with the installation
+// credentials. The keyring is empty if there are no installation credentials.
+func newInstallCredentialsKeyring(ctx context.Context) *credentialprovider.BasicDockerKeyring {
 	installKeyring := &credentialprovider.BasicDockerKeyring{}
 	if config, err := credentialprovider.ReadDockerConfigJSONFile(
 		[]string{installCredentialsDir},
@@ -63,8 +58,34 @@ func getImportContext(ctx context.Context, ref *reference.DockerImageReference,
 	} else {
 		installKeyring.Add(config)
 	}
+	return installKeyring
+}
+
+// lazyInstallCredentialsKeyring is a keyring with the installation
+// credentials that are read on the first lookup.
+type lazyInstallCredentialsKeyring struct {
+	ctx     context.Context
+	once    sync.Once
+	keyring credentialprovider.DockerKeyring
+}
+
+func (k *lazyInstallCredentialsKeyring) Lookup(image string) ([]credentialprovider.LazyAuthConfiguration, bool) {
+	k.once.Do(func() {
+		k.keyring = newInstallCredentialsKeyring(k.ctx)
+	})
+	return k.keyring.Lookup(image)
+}
+
+// getImportContext loads secrets and returns a context for getting
+// distribution clients to remote repositories.
+func getImportContext(ctx context.Context, ref *reference.DockerImageReference, secrets []corev1.Secret, m metrics.Pullthrough, icsp operatorv1alpha1.ImageContentSourcePolicyInterface) (registryclient.RepositoryRetriever, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("unable to get request from context: %v", err)
+		return nil, err
+	}
 
-	keyring, err := credentialprovider.MakeDockerKeyring(secrets, installKeyring)
+	keyring, err := credentialprovider.MakeDockerKeyring(secrets, newInstallCredentialsKeyring(ctx))
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("error creating keyring: %v", err)
 		return nil, err
@@ -0,0 +1,57 @@
+package imagestream
+
+import (
+	"context"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/openshift/image-registry/pkg/dockerregistry/server/audit"
+)
+
+// MappingRecord describes an image stream mapping created by
+// CreateImageStreamMapping.
+type MappingRecord struct {
+	// User and UserID identify the user on whose behalf the mapping has
+	// been created. They are empty if the request context does not carry
+	// the user information.
+	User   string
+	UserID string
+
+	Namespace string
+	Name      string
+	Tag       string
+	Digest    digest.Digest
+
+	// Time is when the mapping has been created.
+	Time time.Time
+
+	// AutoProvisioned is true if the image stream has been created in order
+	// to create the mapping.
+	AutoProvisioned bool
+}
+
+// AuditSink receives a record for each image stream mapping that has been
+// created successfully.
+type AuditSink interface {
+	RecordMapping(ctx context.Context, record MappingRecord)
+}
+
+// recordMapping passes a record of the created mapping to the audit sink, if
+// any.
+func (is *imageStream) recordMapping(ctx context.Context, tag string, dgst string, autoProvisioned bool) {
+	if is.auditSink == nil {
+		return
+	}
+	is.auditSink.RecordMapping(ctx, MappingRecord{
+		User:            dcontext.GetStringValue(ctx, audit.AuditUserEntry),
+		UserID:          dcontext.GetStringValue(ctx, audit.AuditUserIDEntry),
+		Namespace:       is.namespace,
+		Name:            is.name,
+		Tag:             tag,
+		Digest:          digest.Digest(dgst),
+		Time:            is.now(),
+		AutoProvisioned: autoProvisioned,
+	})
+}
@@ -0,0 +1,62 @@
+package imagestream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	"github.com/openshift/image-registry/pkg/dockerregistry/server/audit"
+	"github.com/openshift/image-registry/pkg/dockerregistry/server/client"
+	"github.com/openshift/image-registry/pkg/testutil"
+)
+
+type recordingAuditSink []MappingRecord
+
+func (s *recordingAuditSink) RecordMapping(ctx context.Context, record MappingRecord) {
+	*s = append(*s, record)
+}
+
+func TestAuditSink(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+	ctx = context.WithValue(ctx, audit.AuditUserEntry, "alice")
+	fos, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+	osClient := client.NewFakeRegistryAPIClient(nil, imageClient)
+
+	image, err := fos.CreateImage(&imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: dgst}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sink recordingAuditSink
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	is := New(ctx, "ns", "is", osClient, WithAuditSink(&sink), WithClock(func() time.Time { return now }))
+
+	if err := is.CreateImageStreamMapping(ctx, osClient, "latest", image); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sink) != 1 {
+		t.Fatalf("got %d records, want 1", len(sink))
+	}
+	record := sink[0]
+	if record.User != "alice" || record.Namespace != "ns" || record.Name != "is" || record.Tag != "latest" || record.Digest != dgst || !record.Time.Equal(now) {
+		t.Errorf("unexpected record %#v", record)
+	}
+	if !record.AutoProvisioned {
+		t.Errorf("the image stream has been auto provisioned, but the record says otherwise")
+	}
+
+	stream, err := imageClient.ImageStreams("ns").Get(ctx, "is", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Annotations[AutoProvisionedAnnotation] != "true" {
+		t.Errorf("the auto provisioned image stream is not annotated: %v", stream.Annotations)
+	}
+}
@@ -3,6 +3,9 @@ package imagestream
 import (
 	"context"
 	"fmt"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
 
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,22 +22,57 @@ const (
 	ErrImageStreamGetterUnknownCode   = ErrImageStreamGetterCode + "Unknown"
 	ErrImageStreamGetterNotFoundCode  = ErrImageStreamGetterCode + "NotFound"
 	ErrImageStreamGetterForbiddenCode = ErrImageStreamGetterCode + "Forbidden"
+
+	// ErrImageStreamGetterLayersUnsupportedCode is returned when the image
+	// stream exists, but the master API does not serve its layers
+	// subresource.
+	ErrImageStreamGetterLayersUnsupportedCode = ErrImageStreamGetterCode + "LayersUnsupported"
 )
 
+// ImageStreamGetter retrieves a single image stream and its layers.
+type ImageStreamGetter interface {
+	Get(ctx context.Context) (*imageapiv1.ImageStream, rerrors.Error)
+	Layers(ctx context.Context) (*imageapiv1.ImageStreamLayers, rerrors.Error)
+
+	// CacheImageStream remembers the image stream so that subsequent calls
+	// to Get return it.
+	CacheImageStream(is *imageapiv1.ImageStream)
+}
+
 // cachedImageStreamGetter wraps a master API client for getting image streams with a cache.
 type cachedImageStreamGetter struct {
-	namespace               string
-	name                    string
-	isNamespacer            client.ImageStreamsNamespacer
+	namespace    string
+	name         string
+	isNamespacer client.ImageStreamsNamespacer
+
+	// mu protects the cached objects, the getter may be shared by several
+	// ImageStreams.
+	mu                      sync.Mutex
 	cachedImageStream       *imageapiv1.ImageStream
 	cachedImageStreamLayers *imageapiv1.ImageStreamLayers
 }
 
-func (g *cachedImageStreamGetter) get() (*imageapiv1.ImageStream, rerrors.Error) {
-	if g.cachedImageStream != nil {
-		return g.cachedImageStream, nil
+// NewCachedImageStreamGetter returns an ImageStreamGetter that fetches the
+// image stream namespace/name and its layers using the master API client.
+// The results stay cached for the lifetime of the getter.
+func NewCachedImageStreamGetter(namespace, name string, isNamespacer client.ImageStreamsNamespacer) ImageStreamGetter {
+	return &cachedImageStreamGetter{
+		namespace:    namespace,
+		name:         name,
+		isNamespacer: isNamespacer,
 	}
-	is, err := g.isNamespacer.ImageStreams(g.namespace).Get(context.TODO(), g.name, metav1.GetOptions{})
+}
+
+func (g *cachedImageStreamGetter) Get(ctx context.Context) (*imageapiv1.ImageStream, rerrors.Error) {
+	g.mu.Lock()
+	cached := g.cachedImageStream
+	g.mu.Unlock()
+	if cached != nil && !noCache(ctx) {
+		cacheHit(ctx, CacheImageStream)
+		return cached, nil
+	}
+	cacheMiss(ctx, CacheImageStream)
+	is, err := g.isNamespacer.ImageStreams(g.namespace).Get(ctx, g.name, metav1.GetOptions{})
 	if err != nil {
 		switch {
 		case kerrors.IsNotFound(err):
@@ -46,18 +84,30 @@ func (g *cachedImageStreamGetter) get() (*imageapiv1.ImageStream, rerrors.Error)
 		}
 	}
 
-	g.cachedImageStream = is
+	g.CacheImageStream(is)
 	return is, nil
 }
 
-func (g *cachedImageStreamGetter) layers() (*imageapiv1.ImageStreamLayers, rerrors.Error) {
-	if g.cachedImageStreamLayers != nil {
-		return g.cachedImageStreamLayers, nil
+func (g *cachedImageStreamGetter) Layers(ctx context.Context) (*imageapiv1.ImageStreamLayers, rerrors.Error) {
+	g.mu.Lock()
+	cached := g.cachedImageStreamLayers
+	g.mu.Unlock()
+	if cached != nil && !noCache(ctx) {
+		cacheHit(ctx, CacheImageStreamLayers)
+		return cached, nil
 	}
-	is, err := g.isNamespacer.ImageStreams(g.namespace).Layers(context.TODO(), g.name, metav1.GetOptions{})
+	cacheMiss(ctx, CacheImageStreamLayers)
+	is, err := g.isNamespacer.ImageStreams(g.namespace).Layers(ctx, g.name, metav1.GetOptions{})
 	if err != nil {
 		switch {
+		case kerrors.IsMethodNotSupported(err):
+			return nil, rerrors.NewError(ErrImageStreamGetterLayersUnsupportedCode, fmt.Sprintf("%s/%s", g.namespace, g.name), err)
 		case kerrors.IsNotFound(err):
+			// Older masters do not have the layers subresource. If the image
+			// stream itself can be found, the subresource is not supported.
+			if _, getErr := g.Get(ctx); getErr == nil {
+				return nil, rerrors.NewError(ErrImageStreamGetterLayersUnsupportedCode, fmt.Sprintf("%s/%s", g.namespace, g.name), err)
+			}
 			return nil, rerrors.NewError(ErrImageStreamGetterNotFoundCode, fmt.Sprintf("%s/%s", g.namespace, g.name), err)
 		case kerrors.IsForbidden(err), kerrors.IsUnauthorized(err), quotautil.IsErrorQuotaExceeded(err):
 			return nil, rerrors.NewError(ErrImageStreamGetterForbiddenCode, fmt.Sprintf("%s/%s", g.namespace, g.name), err)
@@ -66,10 +116,40 @@ func (g *cachedImageStreamGetter) layers() (*imageapiv1.ImageStreamLayers, rerro
 		}
 	}
 
+	g.mu.Lock()
 	g.cachedImageStreamLayers = is
+	g.mu.Unlock()
 	return is, nil
 }
 
-func (g *cachedImageStreamGetter) cacheImageStream(is *imageapiv1.ImageStream) {
+func (g *cachedImageStreamGetter) CacheImageStream(is *imageapiv1.ImageStream) {
+	g.mu.Lock()
 	g.cachedImageStream = is
+	g.mu.Unlock()
+}
+
+// observedImageGetter makes the wrapped ImageGetter report cache hits and
+// misses to observer.
+type observedImageGetter struct {
+	ImageGetter
+	observer CacheObserver
+}
+
+func (g *observedImageGetter) Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	return g.ImageGetter.Get(withCacheObserver(ctx, g.observer), dgst)
+}
+
+// observedImageStreamGetter makes the wrapped ImageStreamGetter report cache
+// hits and misses to observer.
+type observedImageStreamGetter struct {
+	ImageStreamGetter
+	observer CacheObserver
+}
+
+func (g *observedImageStreamGetter) Get(ctx context.Context) (*imageapiv1.ImageStream, rerrors.Error) {
+	return g.ImageStreamGetter.Get(withCacheObserver(ctx, g.observer))
+}
+
+func (g *observedImageStreamGetter) Layers(ctx context.Context) (*imageapiv1.ImageStreamLayers, rerrors.Error) {
+	return g.ImageStreamGetter.Layers(withCacheObserver(ctx, g.observer))
 }
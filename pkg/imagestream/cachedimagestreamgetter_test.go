@@ -0,0 +1,138 @@
+package imagestream
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+	imagefakeclient "github.com/openshift/client-go/image/clientset/versioned/typed/image/v1/fake"
+
+	"github.com/openshift/image-registry/pkg/dockerregistry/server/client"
+)
+
+func TestCachedImageStreamGetterLayersUnsupported(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		streamExists bool
+		expectedCode string
+	}{
+		{
+			name:         "missing image stream",
+			expectedCode: ErrImageStreamGetterNotFoundCode,
+		},
+		{
+			name:         "missing layers subresource",
+			streamExists: true,
+			expectedCode: ErrImageStreamGetterLayersUnsupportedCode,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			imageClient := &imagefakeclient.FakeImageV1{Fake: &core.Fake{}}
+			imageClient.AddReactor("get", "imagestreams", func(action core.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() == "" && tc.streamExists {
+					return true, &imageapiv1.ImageStream{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"}}, nil
+				}
+				return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), "is")
+			})
+
+			getter := NewCachedImageStreamGetter("ns", "is", client.NewFakeRegistryAPIClient(nil, imageClient))
+
+			_, err := getter.Layers(context.Background())
+			if err == nil || err.Code() != tc.expectedCode {
+				t.Fatalf("got error %v, want code %s", err, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestCachedImageStreamGetterNoCache(t *testing.T) {
+	imageClient := &imagefakeclient.FakeImageV1{Fake: &core.Fake{}}
+	imageClient.AddReactor("get", "imagestreams", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &imageapiv1.ImageStream{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"}}, nil
+	})
+
+	getter := NewCachedImageStreamGetter("ns", "is", client.NewFakeRegistryAPIClient(nil, imageClient))
+
+	ctx := context.Background()
+	for _, c := range []context.Context{ctx, ctx, WithNoCache(ctx), ctx} {
+		if _, err := getter.Get(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if actions := len(imageClient.Actions()); actions != 2 {
+		t.Errorf("got %d requests to the master API, want 2", actions)
+	}
+}
+
+type countingCacheObserver struct {
+	hits   map[string]int
+	misses map[string]int
+}
+
+func (o *countingCacheObserver) CacheHit(cache string)  { o.hits[cache]++ }
+func (o *countingCacheObserver) CacheMiss(cache string) { o.misses[cache]++ }
+
+func TestCacheObserver(t *testing.T) {
+	imageClient := &imagefakeclient.FakeImageV1{Fake: &core.Fake{}}
+	imageClient.AddReactor("get", "imagestreams", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &imageapiv1.ImageStream{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"}}, nil
+	})
+
+	observer := &countingCacheObserver{hits: map[string]int{}, misses: map[string]int{}}
+	ctx := context.Background()
+	is := New(ctx, "ns", "is", client.NewFakeRegistryAPIClient(nil, imageClient), WithCacheObserver(observer)).(*imageStream)
+
+	for _, c := range []context.Context{ctx, ctx, WithNoCache(ctx), ctx} {
+		if _, err := is.imageStreamGetter.Get(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if hits, misses := observer.hits[CacheImageStream], observer.misses[CacheImageStream]; hits != 2 || misses != 2 {
+		t.Errorf("got %d hits and %d misses, want 2 and 2", hits, misses)
+	}
+}
+
+func TestCacheObserverSharedGetter(t *testing.T) {
+	imageClient := &imagefakeclient.FakeImageV1{Fake: &core.Fake{}}
+	imageClient.AddReactor("get", "imagestreams", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &imageapiv1.ImageStream{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"}}, nil
+	})
+
+	ctx := context.Background()
+	osClient := client.NewFakeRegistryAPIClient(nil, imageClient)
+	getter := NewCachedImageStreamGetter("ns", "is", osClient)
+
+	const calls = 10
+	var wg sync.WaitGroup
+	observers := make([]*countingCacheObserver, 2)
+	for i := range observers {
+		observer := &countingCacheObserver{hits: map[string]int{}, misses: map[string]int{}}
+		observers[i] = observer
+		is := NewWithGetters(ctx, "ns", "is", osClient, NewCachedImageGetter(osClient), getter, WithCacheObserver(observer)).(*imageStream)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				if _, err := is.imageStreamGetter.Get(ctx); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, observer := range observers {
+		if n := observer.hits[CacheImageStream] + observer.misses[CacheImageStream]; n != calls {
+			t.Errorf("observer %d: got %d reports, want %d", i, n, calls)
+		}
+	}
+}
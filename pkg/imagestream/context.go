@@ -0,0 +1,66 @@
+package imagestream
+
+import (
+	"context"
+)
+
+type contextKey string
+
+const (
+	// protectedTagOverrideKey is the key to indicate that protected tags may
+	// be overwritten in Contexts.
+	protectedTagOverrideKey contextKey = "protectedTagOverride"
+
+	// noCacheKey is the key to indicate that cached image streams and
+	// images must not be used in Contexts.
+	noCacheKey contextKey = "noCache"
+
+	// cacheObserverKey is the key for the CacheObserver that the cached
+	// getters report to in Contexts.
+	cacheObserverKey contextKey = "cacheObserver"
+)
+
+// WithProtectedTagOverride returns a new Context with indication that
+// CreateImageStreamMapping may overwrite protected tags.
+func WithProtectedTagOverride(parent context.Context) context.Context {
+	return context.WithValue(parent, protectedTagOverrideKey, true)
+}
+
+// protectedTagOverride reports whether ctx allows to overwrite protected tags.
+func protectedTagOverride(ctx context.Context) bool {
+	override, ok := ctx.Value(protectedTagOverrideKey).(bool)
+	return ok && override
+}
+
+// WithNoCache returns a new Context with indication that image streams and
+// images must be fetched from the master API even if they are cached. The
+// fetched objects still replace the cached ones.
+func WithNoCache(parent context.Context) context.Context {
+	return context.WithValue(parent, noCacheKey, true)
+}
+
+// noCache reports whether ctx requires to bypass caches.
+func noCache(ctx context.Context) bool {
+	noCache, ok := ctx.Value(noCacheKey).(bool)
+	return ok && noCache
+}
+
+// withCacheObserver returns a new Context that makes the cached getters report
+// cache hits and misses to observer.
+func withCacheObserver(parent context.Context, observer CacheObserver) context.Context {
+	return context.WithValue(parent, cacheObserverKey, observer)
+}
+
+// cacheHit reports a hit of cache to the CacheObserver of ctx, if any.
+func cacheHit(ctx context.Context, cache string) {
+	if observer, ok := ctx.Value(cacheObserverKey).(CacheObserver); ok {
+		observer.CacheHit(cache)
+	}
+}
+
+// cacheMiss reports a miss of cache to the CacheObserver of ctx, if any.
+func cacheMiss(ctx context.Context, cache string) {
+	if observer, ok := ctx.Value(cacheObserverKey).(CacheObserver); ok {
+		observer.CacheMiss(cache)
+	}
+}
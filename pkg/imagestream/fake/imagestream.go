@@ -0,0 +1,725 @@
+// Package fake provides an in-memory implementation of imagestream.ImageStream
+// for tests.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	"github.com/openshift/image-registry/pkg/dockerregistry/server/client"
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+	"github.com/openshift/image-registry/pkg/imagestream"
+	"github.com/openshift/image-registry/pkg/kubernetes-common/credentialprovider"
+	"github.com/openshift/image-registry/pkg/origin-common/util"
+	"github.com/openshift/library-go/pkg/image/reference"
+)
+
+// FakeImageStream is an in-memory imagestream.ImageStream. Its behaviour is
+// defined by its exported fields, which may be changed at any time.
+//
+// Methods that are not overridden by FakeImageStream are served by the real
+// implementation operating on Stream, Layers and Images. Methods that need
+// the master API client otherwise are overridden to use the other fields.
+type FakeImageStream struct {
+	imagestream.ImageStream
+
+	Namespace string
+	Name      string
+
+	// Stream is the image stream. A nil value means that the image stream
+	// does not exist.
+	Stream *imageapiv1.ImageStream
+	// Layers are the image stream layers. A nil value means that the layers
+	// cannot be found.
+	Layers *imageapiv1.ImageStreamLayers
+	// Images contains the images known to the master API.
+	Images map[digest.Digest]*imageapiv1.Image
+
+	Secrets     []corev1.Secret
+	LimitRanges *corev1.LimitRangeList
+
+	// AnonymousPull is returned by AllowsAnonymousPull.
+	AnonymousPull bool
+
+	// DefaultKeyring is used by IdentifyCandidateRepositoriesByAuth in
+	// addition to Secrets.
+	DefaultKeyring credentialprovider.DockerKeyring
+
+	// Errors maps method names to the errors they return.
+	Errors map[string]rerrors.Error
+}
+
+var _ imagestream.ImageStream = &FakeImageStream{}
+
+// NewFakeImageStream returns a FakeImageStream for namespace/name with an
+// empty image stream.
+func NewFakeImageStream(namespace, name string) *FakeImageStream {
+	f := &FakeImageStream{
+		Namespace: namespace,
+		Name:      name,
+		Stream: &imageapiv1.ImageStream{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+			},
+		},
+		Layers: &imageapiv1.ImageStreamLayers{},
+		Images: make(map[digest.Digest]*imageapiv1.Image),
+		Errors: make(map[string]rerrors.Error),
+	}
+	f.ImageStream = imagestream.NewWithGetters(context.Background(), namespace, name, nil, imageGetter{f}, imageStreamGetter{f},
+		imagestream.WithImageStreamGetterFactory(f.streamGetter))
+	return f
+}
+
+// AddImage adds the image to Images and makes it the latest image of tag.
+func (f *FakeImageStream) AddImage(tag string, image *imageapiv1.Image) {
+	f.Images[digest.Digest(image.Name)] = image
+
+	event := imageapiv1.TagEvent{
+		Created:              metav1.Now(),
+		DockerImageReference: image.DockerImageReference,
+		Image:                image.Name,
+	}
+	for i := range f.Stream.Status.Tags {
+		history := &f.Stream.Status.Tags[i]
+		if history.Tag == tag {
+			history.Items = append([]imageapiv1.TagEvent{event}, history.Items...)
+			return
+		}
+	}
+	f.Stream.Status.Tags = append(f.Stream.Status.Tags, imageapiv1.NamedTagEventList{
+		Tag:   tag,
+		Items: []imageapiv1.TagEvent{event},
+	})
+}
+
+func (f *FakeImageStream) Reference() string {
+	return fmt.Sprintf("%s/%s", f.Namespace, f.Name)
+}
+
+func (f *FakeImageStream) Exists(ctx context.Context) (bool, rerrors.Error) {
+	if err := f.Errors["Exists"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.Exists(ctx)
+}
+
+func (f *FakeImageStream) GetImageOfImageStream(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	if err := f.Errors["GetImageOfImageStream"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.GetImageOfImageStream(ctx, dgst)
+}
+
+func (f *FakeImageStream) GetStoredImage(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	if err := f.Errors["GetStoredImage"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.GetStoredImage(ctx, dgst)
+}
+
+func (f *FakeImageStream) RewriteReference(ctx context.Context, image *imageapiv1.Image, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	if err := f.Errors["RewriteReference"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.RewriteReference(ctx, image, dgst)
+}
+
+func (f *FakeImageStream) GetImageByTagWithPolicy(ctx context.Context, tag string, honorLocalPolicy bool) (*imageapiv1.Image, rerrors.Error) {
+	if err := f.Errors["GetImageByTagWithPolicy"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.GetImageByTagWithPolicy(ctx, tag, honorLocalPolicy)
+}
+
+func (f *FakeImageStream) ResolveAllTags(ctx context.Context) (map[string]*imageapiv1.Image, rerrors.Error) {
+	if err := f.Errors["ResolveAllTags"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ResolveAllTags(ctx)
+}
+
+func (f *FakeImageStream) ImagesWithLabel(ctx context.Context, key, value string) ([]digest.Digest, rerrors.Error) {
+	if err := f.Errors["ImagesWithLabel"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ImagesWithLabel(ctx, key, value)
+}
+
+func (f *FakeImageStream) ManifestBytes(ctx context.Context, dgst digest.Digest) ([]byte, string, rerrors.Error) {
+	if err := f.Errors["ManifestBytes"]; err != nil {
+		return nil, "", err
+	}
+	return f.ImageStream.ManifestBytes(ctx, dgst)
+}
+
+// CreateImageStreamMapping tags the image in memory. The image stream is
+// created if it does not exist.
+func (f *FakeImageStream) CreateImageStreamMapping(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) rerrors.Error {
+	if err := f.Errors["CreateImageStreamMapping"]; err != nil {
+		return err
+	}
+	if f.Stream == nil {
+		f.Stream = &imageapiv1.ImageStream{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: f.Namespace,
+				Name:      f.Name,
+			},
+		}
+	}
+	f.AddImage(tag, image)
+	return nil
+}
+
+func (f *FakeImageStream) EnsureTag(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) (bool, rerrors.Error) {
+	if err := f.Errors["EnsureTag"]; err != nil {
+		return false, err
+	}
+	if f.Stream != nil && util.LatestTaggedImage(f.Stream, tag) != nil {
+		return false, nil
+	}
+	if err := f.CreateImageStreamMapping(ctx, userClient, tag, image); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *FakeImageStream) ResolveImageID(ctx context.Context, dgst digest.Digest) (*imageapiv1.TagEvent, rerrors.Error) {
+	if err := f.Errors["ResolveImageID"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ResolveImageID(ctx, dgst)
+}
+
+func (f *FakeImageStream) ResolveImageIDBestEffort(ctx context.Context, dgst digest.Digest) (*imageapiv1.TagEvent, rerrors.Error) {
+	if err := f.Errors["ResolveImageIDBestEffort"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ResolveImageIDBestEffort(ctx, dgst)
+}
+
+func (f *FakeImageStream) UpstreamReference(ctx context.Context, dgst digest.Digest) (reference.DockerImageReference, bool, rerrors.Error) {
+	if err := f.Errors["UpstreamReference"]; err != nil {
+		return reference.DockerImageReference{}, false, err
+	}
+	return f.ImageStream.UpstreamReference(ctx, dgst)
+}
+
+func (f *FakeImageStream) ShouldPullthroughBlob(ctx context.Context, dgst digest.Digest) (bool, *imagestream.ImagePullthroughSpec, rerrors.Error) {
+	if err := f.Errors["ShouldPullthroughBlob"]; err != nil {
+		return false, nil, err
+	}
+	return f.ImageStream.ShouldPullthroughBlob(ctx, dgst)
+}
+
+func (f *FakeImageStream) HasBlobs(ctx context.Context, dgsts []digest.Digest) (map[digest.Digest]bool, map[digest.Digest]*imageapiv1.Image, rerrors.Error) {
+	if err := f.Errors["HasBlobs"]; err != nil {
+		return nil, nil, err
+	}
+	return f.ImageStream.HasBlobs(ctx, dgsts)
+}
+
+func (f *FakeImageStream) PreferredRegistry(ctx context.Context, external bool) (string, rerrors.Error) {
+	if err := f.Errors["PreferredRegistry"]; err != nil {
+		return "", err
+	}
+	return f.ImageStream.PreferredRegistry(ctx, external)
+}
+
+func (f *FakeImageStream) IdentifyCandidateRepositories(ctx context.Context, primary bool) ([]string, map[string]imagestream.ImagePullthroughSpec, rerrors.Error) {
+	if err := f.Errors["IdentifyCandidateRepositories"]; err != nil {
+		return nil, nil, err
+	}
+	return f.ImageStream.IdentifyCandidateRepositories(ctx, primary)
+}
+
+func (f *FakeImageStream) IdentifyCandidateReferences(ctx context.Context, primary bool) ([]reference.DockerImageReference, map[string]imagestream.ImagePullthroughSpec, rerrors.Error) {
+	if err := f.Errors["IdentifyCandidateReferences"]; err != nil {
+		return nil, nil, err
+	}
+	return f.ImageStream.IdentifyCandidateReferences(ctx, primary)
+}
+
+func (f *FakeImageStream) IdentifyCandidateRepositoriesLocalFirst(ctx context.Context, primary bool) ([]string, map[string]imagestream.ImagePullthroughSpec, rerrors.Error) {
+	if err := f.Errors["IdentifyCandidateRepositoriesLocalFirst"]; err != nil {
+		return nil, nil, err
+	}
+	return f.ImageStream.IdentifyCandidateRepositoriesLocalFirst(ctx, primary)
+}
+
+func (f *FakeImageStream) IdentifyCandidateRepositoriesByAuth(ctx context.Context, primary bool) ([]string, []string, map[string]imagestream.ImagePullthroughSpec, rerrors.Error) {
+	if err := f.Errors["IdentifyCandidateRepositoriesByAuth"]; err != nil {
+		return nil, nil, nil, err
+	}
+	repositories, search, err := f.IdentifyCandidateRepositories(ctx, primary)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	secrets, err := f.GetSecrets()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authenticated, anonymous, splitErr := imagestream.SplitRepositoriesByAuth(repositories, secrets, f.DefaultKeyring)
+	if splitErr != nil {
+		return nil, nil, nil, rerrors.NewError(imagestream.ErrImageStreamUnknownErrorCode, "IdentifyCandidateRepositoriesByAuth", splitErr)
+	}
+	return authenticated, anonymous, search, nil
+}
+
+// GetLimitRangeList returns LimitRanges. The cache is not used.
+func (f *FakeImageStream) GetLimitRangeList(ctx context.Context, cache imagestream.ProjectObjectListStore, maxAge time.Duration) (*corev1.LimitRangeList, rerrors.Error) {
+	if err := f.Errors["GetLimitRangeList"]; err != nil {
+		return nil, err
+	}
+	if f.LimitRanges == nil {
+		return &corev1.LimitRangeList{}, nil
+	}
+	return f.LimitRanges, nil
+}
+
+// InvalidSecrets returns the names of Secrets that cannot be parsed.
+func (f *FakeImageStream) InvalidSecrets(ctx context.Context) ([]string, rerrors.Error) {
+	if err := f.Errors["InvalidSecrets"]; err != nil {
+		return nil, err
+	}
+	secrets, err := f.GetSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return imagestream.InvalidSecretNames(secrets), nil
+}
+
+// GetSecrets returns Secrets.
+func (f *FakeImageStream) GetSecrets() ([]corev1.Secret, rerrors.Error) {
+	if err := f.Errors["GetSecrets"]; err != nil {
+		return nil, err
+	}
+	return f.Secrets, nil
+}
+
+func (f *FakeImageStream) TagIsInsecure(ctx context.Context, tag string, dgst digest.Digest) (bool, rerrors.Error) {
+	if err := f.Errors["TagIsInsecure"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.TagIsInsecure(ctx, tag, dgst)
+}
+
+func (f *FakeImageStream) DigestIsInsecure(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error) {
+	if err := f.Errors["DigestIsInsecure"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.DigestIsInsecure(ctx, dgst)
+}
+
+func (f *FakeImageStream) IsLocalOnly(ctx context.Context) (bool, rerrors.Error) {
+	if err := f.Errors["IsLocalOnly"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.IsLocalOnly(ctx)
+}
+
+func (f *FakeImageStream) LookupPolicyLocal(ctx context.Context) (bool, rerrors.Error) {
+	if err := f.Errors["LookupPolicyLocal"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.LookupPolicyLocal(ctx)
+}
+
+func (f *FakeImageStream) StreamCreated(ctx context.Context) (metav1.Time, rerrors.Error) {
+	if err := f.Errors["StreamCreated"]; err != nil {
+		return metav1.Time{}, err
+	}
+	return f.ImageStream.StreamCreated(ctx)
+}
+
+func (f *FakeImageStream) AllowsAnonymousPull(ctx context.Context) (bool, rerrors.Error) {
+	if err := f.Errors["AllowsAnonymousPull"]; err != nil {
+		return false, err
+	}
+	return f.AnonymousPull, nil
+}
+
+func (f *FakeImageStream) TriggerAnnotations(ctx context.Context) (string, bool, rerrors.Error) {
+	if err := f.Errors["TriggerAnnotations"]; err != nil {
+		return "", false, err
+	}
+	return f.ImageStream.TriggerAnnotations(ctx)
+}
+
+func (f *FakeImageStream) CanonicalTag(ctx context.Context, tag string) (string, rerrors.Error) {
+	if err := f.Errors["CanonicalTag"]; err != nil {
+		return "", err
+	}
+	return f.ImageStream.CanonicalTag(ctx, tag)
+}
+
+// ValidateTagSource reports a source in any image stream other than the fake
+// image stream as not found.
+func (f *FakeImageStream) ValidateTagSource(ctx context.Context, tag string) rerrors.Error {
+	if err := f.Errors["ValidateTagSource"]; err != nil {
+		return err
+	}
+	return f.ImageStream.ValidateTagSource(ctx, tag)
+}
+
+func (f *FakeImageStream) TagLastImport(ctx context.Context) (map[string]metav1.Time, rerrors.Error) {
+	if err := f.Errors["TagLastImport"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.TagLastImport(ctx)
+}
+
+func (f *FakeImageStream) Generation(ctx context.Context) (int64, int64, rerrors.Error) {
+	if err := f.Errors["Generation"]; err != nil {
+		return 0, 0, err
+	}
+	return f.ImageStream.Generation(ctx)
+}
+
+func (f *FakeImageStream) SpecTags(ctx context.Context) ([]imageapiv1.TagReference, rerrors.Error) {
+	if err := f.Errors["SpecTags"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.SpecTags(ctx)
+}
+
+func (f *FakeImageStream) ImportPolicySummary(ctx context.Context) (imagestream.ImportPolicySummary, rerrors.Error) {
+	if err := f.Errors["ImportPolicySummary"]; err != nil {
+		return imagestream.ImportPolicySummary{}, err
+	}
+	return f.ImageStream.ImportPolicySummary(ctx)
+}
+
+func (f *FakeImageStream) TagsPushedSince(ctx context.Context, since time.Time) (map[string]digest.Digest, rerrors.Error) {
+	if err := f.Errors["TagsPushedSince"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.TagsPushedSince(ctx, since)
+}
+
+func (f *FakeImageStream) Tags(ctx context.Context) (map[string]digest.Digest, rerrors.Error) {
+	if err := f.Errors["Tags"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.Tags(ctx)
+}
+
+func (f *FakeImageStream) TagCount(ctx context.Context) (int, rerrors.Error) {
+	if err := f.Errors["TagCount"]; err != nil {
+		return 0, err
+	}
+	return f.ImageStream.TagCount(ctx)
+}
+
+func (f *FakeImageStream) PendingTagCount(ctx context.Context) (int, rerrors.Error) {
+	if err := f.Errors["PendingTagCount"]; err != nil {
+		return 0, err
+	}
+	return f.ImageStream.PendingTagCount(ctx)
+}
+
+func (f *FakeImageStream) IsEmpty(ctx context.Context) (bool, rerrors.Error) {
+	if err := f.Errors["IsEmpty"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.IsEmpty(ctx)
+}
+
+func (f *FakeImageStream) TagDockerImageReference(ctx context.Context, tag string) (string, rerrors.Error) {
+	if err := f.Errors["TagDockerImageReference"]; err != nil {
+		return "", err
+	}
+	return f.ImageStream.TagDockerImageReference(ctx, tag)
+}
+
+// ResolveCrossStreamTag follows references only within the fake image
+// stream. A reference to any other image stream fails with the code
+// imagestream.ErrImageStreamNotFoundCode.
+func (f *FakeImageStream) ResolveCrossStreamTag(ctx context.Context, tag string) (*imageapiv1.TagEvent, rerrors.Error) {
+	if err := f.Errors["ResolveCrossStreamTag"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ResolveCrossStreamTag(ctx, tag)
+}
+
+func (f *FakeImageStream) MostTaggedImages(ctx context.Context, topN int) ([]imagestream.ImageTagCount, rerrors.Error) {
+	if err := f.Errors["MostTaggedImages"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.MostTaggedImages(ctx, topN)
+}
+
+func (f *FakeImageStream) IsTagHead(ctx context.Context, dgst digest.Digest) (bool, []string, rerrors.Error) {
+	if err := f.Errors["IsTagHead"]; err != nil {
+		return false, nil, err
+	}
+	return f.ImageStream.IsTagHead(ctx, dgst)
+}
+
+func (f *FakeImageStream) ExternalTags(ctx context.Context) (map[string]string, rerrors.Error) {
+	if err := f.Errors["ExternalTags"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ExternalTags(ctx)
+}
+
+func (f *FakeImageStream) UpstreamRegistryCount(ctx context.Context) (int, rerrors.Error) {
+	if err := f.Errors["UpstreamRegistryCount"]; err != nil {
+		return 0, err
+	}
+	return f.ImageStream.UpstreamRegistryCount(ctx)
+}
+
+func (f *FakeImageStream) StaleTags(ctx context.Context, imageExists func(digest.Digest) bool) ([]string, rerrors.Error) {
+	if err := f.Errors["StaleTags"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.StaleTags(ctx, imageExists)
+}
+
+func (f *FakeImageStream) UnreferencedImages(ctx context.Context) ([]digest.Digest, rerrors.Error) {
+	if err := f.Errors["UnreferencedImages"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.UnreferencedImages(ctx)
+}
+
+func (f *FakeImageStream) ResolveLatestOrNewest(ctx context.Context) (*imageapiv1.TagEvent, string, rerrors.Error) {
+	if err := f.Errors["ResolveLatestOrNewest"]; err != nil {
+		return nil, "", err
+	}
+	return f.ImageStream.ResolveLatestOrNewest(ctx)
+}
+
+func (f *FakeImageStream) HasSignature(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error) {
+	if err := f.Errors["HasSignature"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.HasSignature(ctx, dgst)
+}
+
+func (f *FakeImageStream) HistoricalImageCount(ctx context.Context) (int, rerrors.Error) {
+	if err := f.Errors["HistoricalImageCount"]; err != nil {
+		return 0, err
+	}
+	return f.ImageStream.HistoricalImageCount(ctx)
+}
+
+func (f *FakeImageStream) ResolveTagAt(ctx context.Context, tag string, at time.Time) (*imageapiv1.TagEvent, rerrors.Error) {
+	if err := f.Errors["ResolveTagAt"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ResolveTagAt(ctx, tag, at)
+}
+
+func (f *FakeImageStream) StableID(ctx context.Context) (string, rerrors.Error) {
+	if err := f.Errors["StableID"]; err != nil {
+		return "", err
+	}
+	return f.ImageStream.StableID(ctx)
+}
+
+func (f *FakeImageStream) TagsContainingImage(ctx context.Context, dgst digest.Digest) (map[string][]int, rerrors.Error) {
+	if err := f.Errors["TagsContainingImage"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.TagsContainingImage(ctx, dgst)
+}
+
+func (f *FakeImageStream) ExistsAndWritable(ctx context.Context, userClient client.Interface) (bool, bool, rerrors.Error) {
+	if err := f.Errors["ExistsAndWritable"]; err != nil {
+		return false, false, err
+	}
+	return f.ImageStream.ExistsAndWritable(ctx, userClient)
+}
+
+func (f *FakeImageStream) TagsSortedSemver(ctx context.Context) ([]string, rerrors.Error) {
+	if err := f.Errors["TagsSortedSemver"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.TagsSortedSemver(ctx)
+}
+
+func (f *FakeImageStream) ResolveHighestSemverTag(ctx context.Context) (*imageapiv1.TagEvent, string, rerrors.Error) {
+	if err := f.Errors["ResolveHighestSemverTag"]; err != nil {
+		return nil, "", err
+	}
+	return f.ImageStream.ResolveHighestSemverTag(ctx)
+}
+
+func (f *FakeImageStream) Summary(ctx context.Context) (*imagestream.StreamSummary, rerrors.Error) {
+	if err := f.Errors["Summary"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.Summary(ctx)
+}
+
+func (f *FakeImageStream) ImageInStream(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error) {
+	if err := f.Errors["ImageInStream"]; err != nil {
+		return false, err
+	}
+	return f.ImageStream.ImageInStream(ctx, dgst)
+}
+
+func (f *FakeImageStream) CandidatesForDigest(ctx context.Context, dgst digest.Digest) ([]imagestream.ImagePullthroughSpec, rerrors.Error) {
+	if err := f.Errors["CandidatesForDigest"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.CandidatesForDigest(ctx, dgst)
+}
+
+func (f *FakeImageStream) TagReferencePolicies(ctx context.Context) (map[string]imageapiv1.TagReferencePolicyType, rerrors.Error) {
+	if err := f.Errors["TagReferencePolicies"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.TagReferencePolicies(ctx)
+}
+
+func (f *FakeImageStream) DetectTagCycles(ctx context.Context) ([][]string, rerrors.Error) {
+	if err := f.Errors["DetectTagCycles"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.DetectTagCycles(ctx)
+}
+
+func (f *FakeImageStream) PublicHost(ctx context.Context) (string, bool, rerrors.Error) {
+	if err := f.Errors["PublicHost"]; err != nil {
+		return "", false, err
+	}
+	return f.ImageStream.PublicHost(ctx)
+}
+
+func (f *FakeImageStream) ImagesInTimeRange(ctx context.Context, from, to time.Time) ([]digest.Digest, rerrors.Error) {
+	if err := f.Errors["ImagesInTimeRange"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ImagesInTimeRange(ctx, from, to)
+}
+
+func (f *FakeImageStream) TagConditions(ctx context.Context, tag string) ([]imageapiv1.TagEventCondition, rerrors.Error) {
+	if err := f.Errors["TagConditions"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.TagConditions(ctx, tag)
+}
+
+func (f *FakeImageStream) TagHeadStrings(ctx context.Context) (map[string]string, rerrors.Error) {
+	if err := f.Errors["TagHeadStrings"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.TagHeadStrings(ctx)
+}
+
+func (f *FakeImageStream) GetImageWithSource(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, imagestream.ImageSource, rerrors.Error) {
+	if err := f.Errors["GetImageWithSource"]; err != nil {
+		return nil, "", err
+	}
+	return f.ImageStream.GetImageWithSource(ctx, dgst)
+}
+
+func (f *FakeImageStream) ScheduledTags(ctx context.Context) ([]string, rerrors.Error) {
+	if err := f.Errors["ScheduledTags"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.ScheduledTags(ctx)
+}
+
+func (f *FakeImageStream) TagDrift(ctx context.Context) ([]string, []string, rerrors.Error) {
+	if err := f.Errors["TagDrift"]; err != nil {
+		return nil, nil, err
+	}
+	return f.ImageStream.TagDrift(ctx)
+}
+
+func (f *FakeImageStream) GetImageIfAllowed(ctx context.Context, dgst digest.Digest, allowedRegistries []string) (*imageapiv1.Image, rerrors.Error) {
+	if err := f.Errors["GetImageIfAllowed"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.GetImageIfAllowed(ctx, dgst, allowedRegistries)
+}
+
+func (f *FakeImageStream) GetImageStreamTag(ctx context.Context, tag string) (*imageapiv1.ImageStreamTag, rerrors.Error) {
+	if err := f.Errors["GetImageStreamTag"]; err != nil {
+		return nil, err
+	}
+	return f.ImageStream.GetImageStreamTag(ctx, tag)
+}
+
+func (f *FakeImageStream) CommonAncestor(ctx context.Context, tagA, tagB string) (digest.Digest, bool, rerrors.Error) {
+	if err := f.Errors["CommonAncestor"]; err != nil {
+		return "", false, err
+	}
+	return f.ImageStream.CommonAncestor(ctx, tagA, tagB)
+}
+
+// imageGetter serves images from FakeImageStream.Images.
+type imageGetter struct {
+	f *FakeImageStream
+}
+
+func (g imageGetter) Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	image, ok := g.f.Images[dgst]
+	if !ok {
+		return nil, rerrors.NewError(imagestream.ErrImageGetterNotFoundCode, dgst.String(), kerrors.NewNotFound(imageapiv1.Resource("images"), dgst.String()))
+	}
+	return image, nil
+}
+
+// streamGetter returns the getter of the image stream namespace/name.
+// The fake image stream is the only one that exists.
+func (f *FakeImageStream) streamGetter(namespace, name string) imagestream.ImageStreamGetter {
+	if namespace == f.Namespace && name == f.Name {
+		return imageStreamGetter{f}
+	}
+	return missingImageStreamGetter{namespace: namespace, name: name}
+}
+
+// imageStreamGetter serves FakeImageStream.Stream and FakeImageStream.Layers.
+type imageStreamGetter struct {
+	f *FakeImageStream
+}
+
+func (g imageStreamGetter) Get(ctx context.Context) (*imageapiv1.ImageStream, rerrors.Error) {
+	if g.f.Stream == nil {
+		return nil, rerrors.NewError(imagestream.ErrImageStreamGetterNotFoundCode, g.f.Reference(), kerrors.NewNotFound(imageapiv1.Resource("imagestreams"), g.f.Name))
+	}
+	return g.f.Stream, nil
+}
+
+func (g imageStreamGetter) Layers(ctx context.Context) (*imageapiv1.ImageStreamLayers, rerrors.Error) {
+	if g.f.Layers == nil {
+		return nil, rerrors.NewError(imagestream.ErrImageStreamGetterNotFoundCode, g.f.Reference(), kerrors.NewNotFound(imageapiv1.Resource("imagestreams"), g.f.Name))
+	}
+	return g.f.Layers, nil
+}
+
+func (g imageStreamGetter) CacheImageStream(is *imageapiv1.ImageStream) {
+	g.f.Stream = is
+}
+
+// missingImageStreamGetter serves an image stream that does not exist.
+type missingImageStreamGetter struct {
+	namespace string
+	name      string
+}
+
+func (g missingImageStreamGetter) Get(ctx context.Context) (*imageapiv1.ImageStream, rerrors.Error) {
+	return nil, rerrors.NewError(imagestream.ErrImageStreamGetterNotFoundCode, g.namespace+"/"+g.name, kerrors.NewNotFound(imageapiv1.Resource("imagestreams"), g.name))
+}
+
+func (g missingImageStreamGetter) Layers(ctx context.Context) (*imageapiv1.ImageStreamLayers, rerrors.Error) {
+	return nil, rerrors.NewError(imagestream.ErrImageStreamGetterNotFoundCode, g.namespace+"/"+g.name, kerrors.NewNotFound(imageapiv1.Resource("imagestreams"), g.name))
+}
+
+func (g missingImageStreamGetter) CacheImageStream(is *imageapiv1.ImageStream) {
+}
@@ -0,0 +1,102 @@
+package fake
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+	"github.com/openshift/image-registry/pkg/imagestream"
+)
+
+func TestFakeImageStream(t *testing.T) {
+	ctx := context.Background()
+	dgst := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+
+	is := NewFakeImageStream("ns", "is")
+	is.AddImage("latest", &imageapiv1.Image{
+		ObjectMeta:           metav1.ObjectMeta{Name: dgst.String()},
+		DockerImageReference: "docker.io/library/busybox@" + dgst.String(),
+	})
+
+	tags, err := is.Tags(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tags["latest"] != dgst {
+		t.Errorf("got tags %v, want latest pointing to %s", tags, dgst)
+	}
+
+	image, err := is.GetImageOfImageStream(ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image.Name != dgst.String() {
+		t.Errorf("got image %s, want %s", image.Name, dgst)
+	}
+
+	is.Errors["Tags"] = rerrors.NewError(imagestream.ErrImageStreamForbiddenCode, "injected", nil)
+	if _, err := is.Tags(ctx); err == nil || err.Code() != imagestream.ErrImageStreamForbiddenCode {
+		t.Errorf("got error %v, want the injected error", err)
+	}
+
+	is.Stream = nil
+	if exists, err := is.Exists(ctx); err != nil || exists {
+		t.Errorf("got exists=%v, err=%v, want a missing image stream", exists, err)
+	}
+}
+
+func TestFakeImageStreamResolveCrossStreamTag(t *testing.T) {
+	ctx := context.Background()
+	dgst := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+
+	is := NewFakeImageStream("ns", "is")
+	is.AddImage("latest", &imageapiv1.Image{
+		ObjectMeta:           metav1.ObjectMeta{Name: dgst.String()},
+		DockerImageReference: "docker.io/library/busybox@" + dgst.String(),
+	})
+	is.Stream.Spec.Tags = []imageapiv1.TagReference{
+		{Name: "prod", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "is:latest"}},
+		{Name: "other", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "other:b"}},
+	}
+
+	tagEvent, err := is.ResolveCrossStreamTag(ctx, "prod")
+	if err != nil {
+		t.Fatalf("prod: unexpected error: %v", err)
+	}
+	if tagEvent.Image != dgst.String() {
+		t.Errorf("prod: got image %s, want %s", tagEvent.Image, dgst)
+	}
+
+	if _, err := is.ResolveCrossStreamTag(ctx, "other"); err == nil || err.Code() != imagestream.ErrImageStreamNotFoundCode {
+		t.Errorf("other: got error %v, want code %s", err, imagestream.ErrImageStreamNotFoundCode)
+	}
+}
+
+func TestFakeImageStreamValidateTagSource(t *testing.T) {
+	ctx := context.Background()
+	dgst := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+
+	is := NewFakeImageStream("ns", "is")
+	is.AddImage("latest", &imageapiv1.Image{
+		ObjectMeta:           metav1.ObjectMeta{Name: dgst.String()},
+		DockerImageReference: "docker.io/library/busybox@" + dgst.String(),
+	})
+	is.Stream.Spec.Tags = []imageapiv1.TagReference{
+		{Name: "prod", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "latest"}},
+		{Name: "other", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "other:b"}},
+	}
+
+	if err := is.ValidateTagSource(ctx, "prod"); err != nil {
+		t.Errorf("prod: unexpected error: %v", err)
+	}
+	if err := is.ValidateTagSource(ctx, "other"); err == nil || err.Code() != imagestream.ErrImageStreamTagSourceNotFoundCode {
+		t.Errorf("other: got error %v, want code %s", err, imagestream.ErrImageStreamTagSourceNotFoundCode)
+	}
+}
@@ -3,6 +3,7 @@ package imagestream
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	dcontext "github.com/docker/distribution/context"
 	"github.com/opencontainers/go-digest"
@@ -29,16 +30,21 @@ func IsImageManaged(image *imageapiv1.Image) bool {
 	return ok && managed == "true"
 }
 
-type imageGetter interface {
+// ImageGetter retrieves Image resources by their digests.
+type ImageGetter interface {
 	Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error)
 }
 
 type cachedImageGetter struct {
 	client client.Interface
-	cache  map[digest.Digest]*imageapiv1.Image
+
+	mu    sync.Mutex
+	cache map[digest.Digest]*imageapiv1.Image
 }
 
-func newCachedImageGetter(client client.Interface) imageGetter {
+// NewCachedImageGetter returns an ImageGetter that fetches images using the
+// master API client and keeps them cached for its lifetime.
+func NewCachedImageGetter(client client.Interface) ImageGetter {
 	return &cachedImageGetter{
 		client: client,
 		cache:  make(map[digest.Digest]*imageapiv1.Image),
@@ -47,10 +53,15 @@ func newCachedImageGetter(client client.Interface) imageGetter {
 
 // Get retrieves the Image resource with the digest dgst. No authorization check is made.
 func (ig *cachedImageGetter) Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
-	if image, ok := ig.cache[dgst]; ok {
+	ig.mu.Lock()
+	image, ok := ig.cache[dgst]
+	ig.mu.Unlock()
+	if ok && !noCache(ctx) {
 		dcontext.GetLogger(ctx).Debugf("(*cachedImageGetter).Get: found image %s in cache", image.Name)
+		cacheHit(ctx, CacheImage)
 		return image, nil
 	}
+	cacheMiss(ctx, CacheImage)
 
 	image, err := ig.client.Images().Get(ctx, dgst.String(), metav1.GetOptions{})
 	if err != nil {
@@ -73,7 +84,9 @@ func (ig *cachedImageGetter) Get(ctx context.Context, dgst digest.Digest) (*imag
 		)
 	}
 
+	ig.mu.Lock()
 	ig.cache[dgst] = image
+	ig.mu.Unlock()
 
 	return image, nil
 }
@@ -27,7 +27,7 @@ func TestCachedImageGetter(t *testing.T) {
 	ctx = testutil.WithTestLogger(ctx, t)
 	imageClient := &imagefakeclient.FakeImageV1{Fake: &core.Fake{}}
 
-	imageGetter := newCachedImageGetter(client.NewFakeRegistryAPIClient(nil, imageClient))
+	imageGetter := NewCachedImageGetter(client.NewFakeRegistryAPIClient(nil, imageClient))
 	imageClient.AddReactor("get", "images", func(action core.Action) (bool, runtime.Object, error) {
 		return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), action.GetResource().Resource)
 	})
@@ -4,20 +4,29 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/blang/semver/v4"
 	dcontext "github.com/docker/distribution/context"
 	"github.com/opencontainers/go-digest"
 
+	authorizationapi "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/user"
 
 	imageapiv1 "github.com/openshift/api/image/v1"
 
 	"github.com/openshift/image-registry/pkg/dockerregistry/server/client"
 	rerrors "github.com/openshift/image-registry/pkg/errors"
+	"github.com/openshift/image-registry/pkg/kubernetes-common/credentialprovider"
+	imageapi "github.com/openshift/image-registry/pkg/origin-common/image/apis/image"
 	util "github.com/openshift/image-registry/pkg/origin-common/util"
 	"github.com/openshift/library-go/pkg/image/reference"
 	"github.com/openshift/library-go/pkg/quota/quotautil"
@@ -29,8 +38,55 @@ const (
 	ErrImageStreamNotFoundCode      = ErrImageStreamCode + "NotFound"
 	ErrImageStreamImageNotFoundCode = ErrImageStreamCode + "ImageNotFound"
 	ErrImageStreamForbiddenCode     = ErrImageStreamCode + "Forbidden"
+
+	// ErrImageStreamLayersUnsupportedCode is returned when the master API
+	// does not serve the image stream layers subresource. Callers may fall
+	// back to discovering the layers from the manifests.
+	ErrImageStreamLayersUnsupportedCode = ErrImageStreamCode + "LayersUnsupported"
+
+	// ErrImageStreamProvisionRetryFailedCode is returned when an
+	// ImageStreamMapping cannot be created even after the image stream has
+	// been auto provisioned.
+	ErrImageStreamProvisionRetryFailedCode = ErrImageStreamCode + "ProvisionRetryFailed"
+
+	// ErrImageStreamNotManifestListCode is returned when a manifest list is
+	// expected, but the image is a single manifest.
+	ErrImageStreamNotManifestListCode = ErrImageStreamCode + "NotManifestList"
+
+	// ErrImageStreamInvalidDigestCode is returned when the image stream
+	// records an image digest that cannot be parsed.
+	ErrImageStreamInvalidDigestCode = ErrImageStreamCode + "InvalidDigest"
+
+	// ErrImageStreamConflictCode is returned when an ImageStreamMapping
+	// cannot be created because of concurrent updates of the image stream,
+	// even after retrying.
+	ErrImageStreamConflictCode = ErrImageStreamCode + "Conflict"
+
+	// ErrImageStreamManifestListCode is returned when a single manifest is
+	// expected, but the image is a manifest list.
+	ErrImageStreamManifestListCode = ErrImageStreamCode + "ManifestList"
+
+	// ErrImageStreamManifestUnavailableCode is returned when the image does
+	// not carry its manifest.
+	ErrImageStreamManifestUnavailableCode = ErrImageStreamCode + "ManifestUnavailable"
+
+	// ErrImageStreamTagSourceInvalidCode is returned when the From reference
+	// of a spec tag cannot be parsed.
+	ErrImageStreamTagSourceInvalidCode = ErrImageStreamCode + "TagSourceInvalid"
+
+	// ErrImageStreamTagSourceNotFoundCode is returned when the image stream
+	// tag referenced by a spec tag does not exist.
+	ErrImageStreamTagSourceNotFoundCode = ErrImageStreamCode + "TagSourceNotFound"
 )
 
+// AutoProvisionedAnnotation is set to "true" on image streams that have been
+// created by the registry when an image was pushed into a nonexistent image
+// stream.
+const AutoProvisionedAnnotation = "image.openshift.io/auto-provisioned"
+
+// TriggersAnnotation holds the image change triggers of an object.
+const TriggersAnnotation = "image.openshift.io/triggers"
+
 // ProjectObjectListStore represents a cache of objects indexed by a project name.
 // Used to store a list of items per namespace.
 type ProjectObjectListStore interface {
@@ -38,11 +94,72 @@ type ProjectObjectListStore interface {
 	Get(namespace string) (obj runtime.Object, exists bool, err error)
 }
 
+// TimestampedProjectObjectListStore is a ProjectObjectListStore that knows
+// when its entries have been added.
+type TimestampedProjectObjectListStore interface {
+	ProjectObjectListStore
+	GetWithTimestamp(namespace string) (obj runtime.Object, added time.Time, exists bool, err error)
+}
+
 // ImagePullthroughSpec contains a reference of remote image to pull associated with an insecure flag for the
 // corresponding registry.
 type ImagePullthroughSpec struct {
 	DockerImageReference *reference.DockerImageReference
 	Insecure             bool
+	// LocalFirst is set for the repository of the image stream in the
+	// integrated registry. It should be tried before any remote repository.
+	LocalFirst bool
+}
+
+// ImportPolicySummary counts the spec tags of an image stream by their import
+// and reference policies.
+type ImportPolicySummary struct {
+	// Tags is the number of spec tags.
+	Tags int
+	// Scheduled is the number of tags that are periodically imported.
+	Scheduled int
+	// Insecure is the number of tags that may be imported over insecure
+	// connections.
+	Insecure int
+	// LocalReferencePolicy is the number of tags whose images are pulled
+	// through the integrated registry.
+	LocalReferencePolicy int
+}
+
+// ImageTagCount is the number of tags that reference an image.
+type ImageTagCount struct {
+	Digest   digest.Digest
+	TagCount int
+}
+
+// ImageSource describes how GetImageWithSource has found an image.
+type ImageSource string
+
+const (
+	// ImageSourceLocal is used for images that are recorded in the image
+	// stream, either in a tag history or in the image stream layers.
+	ImageSourceLocal ImageSource = "Local"
+
+	// ImageSourceUpstream is used for sub-manifests of manifest lists that
+	// are recorded in the image stream. They are resolved using the
+	// reference of their parent manifest list.
+	ImageSourceUpstream ImageSource = "Upstream"
+)
+
+// StreamSummary is a compact description of an image stream.
+type StreamSummary struct {
+	// Reference is the namespace/name of the image stream.
+	Reference string `json:"reference"`
+	// TagCount is the number of tags that point to an image.
+	TagCount int `json:"tagCount"`
+	// ImageCount is the number of distinct images the tags point to.
+	ImageCount int `json:"imageCount"`
+	// LastPushTime is the creation time of the newest tag event. It is null
+	// if no tag points to an image.
+	LastPushTime metav1.Time `json:"lastPushTime"`
+	// DanglingTagCount is the number of spec tags that do not point to an
+	// image.
+	DanglingTagCount int `json:"danglingTagCount"`
 }
 
 type ImageStream interface {
@@ -50,16 +167,101 @@ type ImageStream interface {
 	Exists(ctx context.Context) (bool, rerrors.Error)
 
 	GetImageOfImageStream(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error)
+	ImageInStream(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error)
+	GetImageStreamTag(ctx context.Context, tag string) (*imageapiv1.ImageStreamTag, rerrors.Error)
+	GetImageIfAllowed(ctx context.Context, dgst digest.Digest, allowedRegistries []string) (*imageapiv1.Image, rerrors.Error)
+	GetImageWithSource(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, ImageSource, rerrors.Error)
+	GetStoredImage(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error)
+	RewriteReference(ctx context.Context, image *imageapiv1.Image, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error)
+	GetImageByTagWithPolicy(ctx context.Context, tag string, honorLocalPolicy bool) (*imageapiv1.Image, rerrors.Error)
+	ResolveAllTags(ctx context.Context) (map[string]*imageapiv1.Image, rerrors.Error)
+	ImagesWithLabel(ctx context.Context, key, value string) ([]digest.Digest, rerrors.Error)
+	ManifestBytes(ctx context.Context, dgst digest.Digest) ([]byte, string, rerrors.Error)
+	HasSignature(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error)
 	CreateImageStreamMapping(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) rerrors.Error
+	ExistsAndWritable(ctx context.Context, userClient client.Interface) (bool, bool, rerrors.Error)
+	EnsureTag(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) (bool, rerrors.Error)
 	ResolveImageID(ctx context.Context, dgst digest.Digest) (*imageapiv1.TagEvent, rerrors.Error)
+	ResolveImageIDBestEffort(ctx context.Context, dgst digest.Digest) (*imageapiv1.TagEvent, rerrors.Error)
+	UpstreamReference(ctx context.Context, dgst digest.Digest) (reference.DockerImageReference, bool, rerrors.Error)
 
 	HasBlob(ctx context.Context, dgst digest.Digest) (bool, *imageapiv1.ImageStreamLayers, *imageapiv1.Image)
+	ShouldPullthroughBlob(ctx context.Context, dgst digest.Digest) (bool, *ImagePullthroughSpec, rerrors.Error)
+	HasBlobs(ctx context.Context, dgsts []digest.Digest) (map[digest.Digest]bool, map[digest.Digest]*imageapiv1.Image, rerrors.Error)
+	PublicHost(ctx context.Context) (string, bool, rerrors.Error)
+	PreferredRegistry(ctx context.Context, external bool) (string, rerrors.Error)
 	IdentifyCandidateRepositories(ctx context.Context, primary bool) ([]string, map[string]ImagePullthroughSpec, rerrors.Error)
-	GetLimitRangeList(ctx context.Context, cache ProjectObjectListStore) (*corev1.LimitRangeList, rerrors.Error)
+	IdentifyCandidateReferences(ctx context.Context, primary bool) ([]reference.DockerImageReference, map[string]ImagePullthroughSpec, rerrors.Error)
+	CandidatesForDigest(ctx context.Context, dgst digest.Digest) ([]ImagePullthroughSpec, rerrors.Error)
+	IdentifyCandidateRepositoriesLocalFirst(ctx context.Context, primary bool) ([]string, map[string]ImagePullthroughSpec, rerrors.Error)
+	IdentifyCandidateRepositoriesByAuth(ctx context.Context, primary bool) ([]string, []string, map[string]ImagePullthroughSpec, rerrors.Error)
+	GetLimitRangeList(ctx context.Context, cache ProjectObjectListStore, maxAge time.Duration) (*corev1.LimitRangeList, rerrors.Error)
 	GetSecrets() ([]corev1.Secret, rerrors.Error)
+	InvalidSecrets(ctx context.Context) ([]string, rerrors.Error)
 
 	TagIsInsecure(ctx context.Context, tag string, dgst digest.Digest) (bool, rerrors.Error)
+	DigestIsInsecure(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error)
+	IsLocalOnly(ctx context.Context) (bool, rerrors.Error)
+	LookupPolicyLocal(ctx context.Context) (bool, rerrors.Error)
+	StreamCreated(ctx context.Context) (metav1.Time, rerrors.Error)
+	StableID(ctx context.Context) (string, rerrors.Error)
+	SpecTags(ctx context.Context) ([]imageapiv1.TagReference, rerrors.Error)
+	ImportPolicySummary(ctx context.Context) (ImportPolicySummary, rerrors.Error)
+	TagReferencePolicies(ctx context.Context) (map[string]imageapiv1.TagReferencePolicyType, rerrors.Error)
+	ScheduledTags(ctx context.Context) ([]string, rerrors.Error)
+	TagLastImport(ctx context.Context) (map[string]metav1.Time, rerrors.Error)
+	TagsPushedSince(ctx context.Context, since time.Time) (map[string]digest.Digest, rerrors.Error)
+	ResolveLatestOrNewest(ctx context.Context) (*imageapiv1.TagEvent, string, rerrors.Error)
+	ResolveTagAt(ctx context.Context, tag string, at time.Time) (*imageapiv1.TagEvent, rerrors.Error)
+	Generation(ctx context.Context) (int64, int64, rerrors.Error)
+	AllowsAnonymousPull(ctx context.Context) (bool, rerrors.Error)
+	TriggerAnnotations(ctx context.Context) (string, bool, rerrors.Error)
 	Tags(ctx context.Context) (map[string]digest.Digest, rerrors.Error)
+	TagHeadStrings(ctx context.Context) (map[string]string, rerrors.Error)
+	TagCount(ctx context.Context) (int, rerrors.Error)
+	TagsSortedSemver(ctx context.Context) ([]string, rerrors.Error)
+	ResolveHighestSemverTag(ctx context.Context) (*imageapiv1.TagEvent, string, rerrors.Error)
+	HistoricalImageCount(ctx context.Context) (int, rerrors.Error)
+	IsEmpty(ctx context.Context) (bool, rerrors.Error)
+	PendingTagCount(ctx context.Context) (int, rerrors.Error)
+	TagDrift(ctx context.Context) ([]string, []string, rerrors.Error)
+	Summary(ctx context.Context) (*StreamSummary, rerrors.Error)
+	TagDockerImageReference(ctx context.Context, tag string) (string, rerrors.Error)
+	TagConditions(ctx context.Context, tag string) ([]imageapiv1.TagEventCondition, rerrors.Error)
+	ResolveCrossStreamTag(ctx context.Context, tag string) (*imageapiv1.TagEvent, rerrors.Error)
+	CanonicalTag(ctx context.Context, tag string) (string, rerrors.Error)
+	DetectTagCycles(ctx context.Context) ([][]string, rerrors.Error)
+	ValidateTagSource(ctx context.Context, tag string) rerrors.Error
+	MostTaggedImages(ctx context.Context, topN int) ([]ImageTagCount, rerrors.Error)
+	IsTagHead(ctx context.Context, dgst digest.Digest) (bool, []string, rerrors.Error)
+	CommonAncestor(ctx context.Context, tagA, tagB string) (digest.Digest, bool, rerrors.Error)
+	TagsContainingImage(ctx context.Context, dgst digest.Digest) (map[string][]int, rerrors.Error)
+	ExternalTags(ctx context.Context) (map[string]string, rerrors.Error)
+	UpstreamRegistryCount(ctx context.Context) (int, rerrors.Error)
+	StaleTags(ctx context.Context, imageExists func(digest.Digest) bool) ([]string, rerrors.Error)
+	UnreferencedImages(ctx context.Context) ([]digest.Digest, rerrors.Error)
+	ImagesInTimeRange(ctx context.Context, from, to time.Time) ([]digest.Digest, rerrors.Error)
+}
+
+// BlobStats reports how the blobs of an image stream are shared between its
+// images. The image streams returned by New and NewWithGetters implement it.
+type BlobStats interface {
+	BlobCount(ctx context.Context) (int, rerrors.Error)
+	UniqueBlobSize(ctx context.Context) (int64, rerrors.Error)
+	ImageLayerMap(ctx context.Context) (map[digest.Digest][]digest.Digest, rerrors.Error)
+	SharedLayers(ctx context.Context, a, b digest.Digest) ([]digest.Digest, rerrors.Error)
+	SharedLayerCountWith(ctx context.Context, layers []digest.Digest) (int, rerrors.Error)
+	UniqueBlobsForImage(ctx context.Context, dgst digest.Digest) ([]digest.Digest, rerrors.Error)
+	DeletionSafety(ctx context.Context, dgst digest.Digest) (*DeletionReport, rerrors.Error)
+}
+
+// ImageInspector reports the platforms and the blobs of the images of an
+// image stream. The image streams returned by New and NewWithGetters
+// implement it.
+type ImageInspector interface {
+	ImagePlatform(ctx context.Context, dgst digest.Digest) (string, string, string, rerrors.Error)
+	AvailablePlatforms(ctx context.Context, tag string) ([]imageapiv1.ImageManifest, rerrors.Error)
+	ImageLineage(ctx context.Context, dgst digest.Digest) (*ImageLineage, rerrors.Error)
 }
 
 type imageStream struct {
@@ -68,37 +270,153 @@ type imageStream struct {
 
 	registryOSClient client.Interface
 
-	imageClient imageGetter
+	imageClient ImageGetter
 
 	// imageStreamGetter fetches and caches an image stream.
 	// The image stream stays cached for the entire time of handling single
 	// repository-scoped request.
-	imageStreamGetter *cachedImageStreamGetter
+	imageStreamGetter ImageStreamGetter
+
+	// maxManifestListDepth is the maximum number of parent manifest lists
+	// walked through by resolveUpstreamRef.
+	maxManifestListDepth int
+
+	// imageFetchConcurrency is the maximum number of images fetched
+	// concurrently by getImages.
+	imageFetchConcurrency int
+
+	// protectedTags are the tags that cannot be moved once they point to an
+	// image.
+	protectedTags map[string]bool
+
+	// onMalformedDigest is called for tags with unparsable digests.
+	onMalformedDigest func(tag, value string)
+
+	// strictDigestParsing makes Tags fail on unparsable digests instead of
+	// skipping them.
+	strictDigestParsing bool
+
+	// preferPublic makes the public repository of the image stream the
+	// first local candidate.
+	preferPublic bool
+
+	// mirrorResolver, if set, returns mirrors of images that are preferred
+	// over the repositories the images have been imported from.
+	mirrorResolver func(digest.Digest) (reference.DockerImageReference, bool)
+
+	// imageTransform, if set, modifies the images returned by
+	// GetImageOfImageStream.
+	imageTransform func(*imageapiv1.Image) *imageapiv1.Image
+
+	// annotateAutoProvisioned makes auto provisioned image streams carry
+	// AutoProvisionedAnnotation.
+	annotateAutoProvisioned bool
+
+	// includeEmptyTags makes Tags report tags without images.
+	includeEmptyTags bool
+
+	// digestAliases maps requested digests to the digests under which the
+	// images are recorded.
+	digestAliases map[digest.Digest]digest.Digest
+
+	// tracer, if set, is used to start spans around master API calls.
+	tracer Tracer
+
+	// getterFactory, if set, creates the getters of other image streams.
+	getterFactory ImageStreamGetterFactory
+
+	// redactErrors makes the methods used to serve clients return errors
+	// with generic messages.
+	redactErrors bool
+
+	// auditSink, if set, receives records of created image stream mappings.
+	auditSink AuditSink
+
+	// cacheObserver, if set, is notified about hits and misses of the
+	// cached getters.
+	cacheObserver CacheObserver
+
+	// clock, if set, is used instead of time.Now by time-dependent logic.
+	clock func() time.Time
+
+	// candidateRanker, if set, orders the candidates returned by
+	// IdentifyCandidateRepositories.
+	candidateRanker CandidateRanker
+
+	// defaultKeyring, if set, provides the credentials that are used in
+	// addition to the image stream's secrets.
+	defaultKeyring credentialprovider.DockerKeyring
+
+	// anonymousPull caches the answer of AllowsAnonymousPull.
+	anonymousPull *anonymousPullCache
 }
 
 var _ ImageStream = &imageStream{}
+var _ BlobStats = &imageStream{}
+var _ ImageInspector = &imageStream{}
 
-func New(ctx context.Context, namespace, name string, client client.Interface) ImageStream {
-	return &imageStream{
-		namespace:        namespace,
-		name:             name,
-		registryOSClient: client,
-		imageClient:      newCachedImageGetter(client),
-		imageStreamGetter: &cachedImageStreamGetter{
-			namespace:    namespace,
-			name:         name,
-			isNamespacer: client,
-		},
+// anonymousPullCache holds the answer of AllowsAnonymousPull once it is known.
+type anonymousPullCache struct {
+	mu      sync.Mutex
+	allowed *bool
+}
+
+func New(ctx context.Context, namespace, name string, client client.Interface, opts ...Option) ImageStream {
+	return NewWithGetters(ctx, namespace, name, client, NewCachedImageGetter(client), NewCachedImageStreamGetter(namespace, name, client), opts...)
+}
+
+// NewWithGetters is like New, but images and the image stream are retrieved
+// using the provided getters instead of the master API client. It allows to
+// stub or wrap image retrieval, e.g. in tests.
+func NewWithGetters(ctx context.Context, namespace, name string, client client.Interface, imageGetter ImageGetter, imageStreamGetter ImageStreamGetter, opts ...Option) ImageStream {
+	is := &imageStream{
+		namespace:               namespace,
+		name:                    name,
+		registryOSClient:        client,
+		imageClient:             imageGetter,
+		imageStreamGetter:       imageStreamGetter,
+		maxManifestListDepth:    defaultMaxManifestListDepth,
+		imageFetchConcurrency:   defaultImageFetchConcurrency,
+		annotateAutoProvisioned: true,
+		anonymousPull:           &anonymousPullCache{},
+	}
+	for _, opt := range opts {
+		opt(is)
+	}
+	if is.cacheObserver != nil {
+		is.imageClient = &observedImageGetter{ImageGetter: is.imageClient, observer: is.cacheObserver}
+		is.imageStreamGetter = &observedImageStreamGetter{ImageStreamGetter: is.imageStreamGetter, observer: is.cacheObserver}
 	}
+	if is.tracer != nil {
+		is.imageClient = &tracingImageGetter{ImageGetter: is.imageClient, tracer: is.tracer}
+		is.imageStreamGetter = &tracingImageStreamGetter{ImageStreamGetter: is.imageStreamGetter, tracer: is.tracer}
+	}
+	return is
 }
 
 func (is *imageStream) Reference() string {
 	return fmt.Sprintf("%s/%s", is.namespace, is.name)
 }
 
+// now returns the current time according to the clock of is.
+func (is *imageStream) now() time.Time {
+	if is.clock != nil {
+		return is.clock()
+	}
+	return time.Now()
+}
+
 // getImage retrieves the Image with digest `dgst`. No authorization check is done.
+// If the image cannot be found, the image recorded under the digest's alias
+// is retrieved.
 func (is *imageStream) getImage(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
 	image, err := is.imageClient.Get(ctx, dgst)
+	if kerrors.IsNotFound(err) {
+		if alias, ok := is.digestAliases[dgst]; ok {
+			dcontext.GetLogger(ctx).Debugf("getImage: image %s not found, trying its alias %s", dgst.String(), alias.String())
+			image, err = is.imageClient.Get(ctx, alias)
+		}
+	}
 
 	switch {
 	case kerrors.IsNotFound(err):
@@ -119,15 +437,29 @@ func (is *imageStream) getImage(ctx context.Context, dgst digest.Digest) (*image
 }
 
 // ResolveImageID returns latest TagEvent for specified imageID and an error if
-// there's more than one image matching the ID or when one does not exist.
-func (is *imageStream) ResolveImageID(ctx context.Context, dgst digest.Digest) (*imageapiv1.TagEvent, rerrors.Error) {
-	stream, rErr := is.imageStreamGetter.get()
+// there's more than one image matching the ID or when one does not exist. If
+// no image matches the ID, the digest's alias is resolved instead.
+func (is *imageStream) ResolveImageID(ctx context.Context, dgst digest.Digest) (_ *imageapiv1.TagEvent, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	return is.resolveImageID(ctx, dgst)
+}
+
+// resolveImageID is ResolveImageID without error redaction.
+func (is *imageStream) resolveImageID(ctx context.Context, dgst digest.Digest) (*imageapiv1.TagEvent, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
 
 	if rErr != nil {
 		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("ResolveImageID: failed to get image stream %s", is.Reference()))
 	}
 
 	tagEvent, err := util.ResolveImageID(stream, dgst.String())
+	if kerrors.IsNotFound(err) {
+		if alias, ok := is.digestAliases[dgst]; ok {
+			dcontext.GetLogger(ctx).Debugf("ResolveImageID: image %s not found, trying its alias %s", dgst.String(), alias.String())
+			tagEvent, err = util.ResolveImageID(stream, alias.String())
+		}
+	}
 	if err != nil {
 		code := ErrImageStreamUnknownErrorCode
 
@@ -145,6 +477,34 @@ func (is *imageStream) ResolveImageID(ctx context.Context, dgst digest.Digest) (
 	return tagEvent, nil
 }
 
+// ResolveImageIDBestEffort is like ResolveImageID, but if ResolveImageID
+// fails, the tag histories are scanned for an entry with exactly the given
+// digest and the first match is returned. It allows to resolve images in
+// image streams with partially malformed histories.
+func (is *imageStream) ResolveImageIDBestEffort(ctx context.Context, dgst digest.Digest) (*imageapiv1.TagEvent, rerrors.Error) {
+	tagEvent, rErr := is.resolveImageID(ctx, dgst)
+	if rErr == nil {
+		return tagEvent, nil
+	}
+
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, rErr
+	}
+
+	for _, history := range stream.Status.Tags {
+		for i := range history.Items {
+			if history.Items[i].Image == dgst.String() {
+				dcontext.GetLogger(ctx).Warnf("ResolveImageIDBestEffort: resolved %s in image stream %s by scanning tag %s after: %v", dgst.String(), is.Reference(), history.Tag, rErr)
+				tagEvent := history.Items[i]
+				return &tagEvent, nil
+			}
+		}
+	}
+
+	return nil, rErr
+}
+
 // getStoredImageOfImageStream retrieves the Image with digest `dgst` and
 // ensures that the image belongs to the image stream `is`. It uses two
 // queries to master API:
@@ -157,7 +517,7 @@ func (is *imageStream) ResolveImageID(ctx context.Context, dgst digest.Digest) (
 // If you need the image object to be modified according to image stream tag,
 // please use GetImageOfImageStream.
 func (is *imageStream) getStoredImageOfImageStream(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, *imageapiv1.TagEvent, rerrors.Error) {
-	tagEvent, err := is.ResolveImageID(ctx, dgst)
+	tagEvent, err := is.resolveImageID(ctx, dgst)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -170,17 +530,37 @@ func (is *imageStream) getStoredImageOfImageStream(ctx context.Context, dgst dig
 	return image, tagEvent, nil
 }
 
-func (is *imageStream) getImageOfImageStream(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
-	image, tagEvent, err := is.getStoredImageOfImageStream(ctx, dgst)
+// GetStoredImage retrieves the Image with the given digest and ensures that
+// it belongs to the image stream. Unlike GetImageOfImageStream, the image is
+// returned as it is stored by the master API, so it is safe to use it in
+// objects that are sent back to the master API, e.g. in image stream
+// mappings.
+func (is *imageStream) GetStoredImage(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	image, _, err := is.getStoredImageOfImageStream(ctx, dgst)
 	if err != nil {
 		return nil, err
 	}
+	return image, nil
+}
+
+// getMemberImage retrieves the Image with the given digest as it is stored by
+// the master API if the image belongs to the image stream in any of the ways
+// GetImageOfImageStream recognizes, e.g. as a sub-manifest of a manifest list
+// in the image stream.
+func (is *imageStream) getMemberImage(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	if _, _, _, err := is.lookupImageReference(ctx, dgst); err != nil {
+		return nil, err
+	}
+	return is.getImage(ctx, dgst)
+}
 
-	// We don't want to mutate the origial image object, which we've got by reference.
+// imageWithReference returns a copy of image whose field DockerImageReference
+// is set to ref.
+func imageWithReference(image *imageapiv1.Image, ref string) *imageapiv1.Image {
+	// We don't want to mutate the original image object, which we've got by reference.
 	img := *image
-	img.DockerImageReference = tagEvent.DockerImageReference
-
-	return &img, nil
+	img.DockerImageReference = ref
+	return &img
 }
 
 // GetImageOfImageStream retrieves the Image with the given digest for the image
@@ -192,233 +572,2425 @@ func (is *imageStream) getImageOfImageStream(ctx context.Context, dgst digest.Di
 // list in an image stream will not be available in the image stream history,
 // only its parent manifest list will be found there.
 //
+// The layers API also lists images whose tag events have been pruned from
+// the history, e.g. due to history limits. Such images are found as well, but
+// as there is no tag event to match, their DockerImageReference is returned
+// unmodified.
+//
 // If the Image with the given digest is not part of the image stream, a not found
 // error is returned.
 //
+// If an image transform is configured using WithImageTransform, it is
+// applied after DockerImageReference has been modified.
+//
 // NOTE: due to on the fly modification, the returned image object should
 // not be sent to the master API. If you need unmodified version of the
-// image object, please use getStoredImageOfImageStream.
+// image object, please use GetStoredImage.
 func (is *imageStream) GetImageOfImageStream(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
-	isImage, err := is.getImageOfImageStream(ctx, dgst)
-	if err == nil {
-		return isImage, nil
-	}
+	image, _, err := is.GetImageWithSource(ctx, dgst)
+	return image, err
+}
 
-	ref, err := is.resolveUpstreamRef(ctx, dgst)
-	if err != nil {
-		return nil, err
-	}
+// GetImageWithSource is like GetImageOfImageStream, but it also returns how
+// the image has been found.
+func (is *imageStream) GetImageWithSource(ctx context.Context, dgst digest.Digest) (_ *imageapiv1.Image, _ ImageSource, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
 
-	image, err := is.getImage(ctx, dgst)
+	return is.getImageWithSource(ctx, dgst)
+}
+
+// getImageWithSource is GetImageWithSource without error redaction.
+func (is *imageStream) getImageWithSource(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, ImageSource, rerrors.Error) {
+	image, source, err := is.getRewrittenImageOfImageStream(ctx, dgst)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	// We don't want to mutate the origial image object, which we've got by reference.
-	img := *image
-	img.DockerImageReference = ref.String()
+	if is.imageTransform != nil {
+		// The rewritten image shares nested objects with the cached one.
+		image = is.imageTransform(image.DeepCopy())
+	}
 
-	return &img, nil
+	return image, source, nil
 }
 
-// resolveUpstreamRef returns an image reference for an image with the given
-// digest that can be used to pull the image from the upstream repository.
-//
-// It uses the image layers API to find the parent image, and then finds the
-// upstream repository for the parent image in the image stream.
-//
-// It works only for sub-manifests, for which the image stream usually does not
-// have a history entry. For the main manifest, the image stream should have a
-// history entry that can be found by ResolveImageID.
-func (is *imageStream) resolveUpstreamRef(ctx context.Context, dgst digest.Digest) (reference.DockerImageReference, rerrors.Error) {
-	layers, rErr := is.imageStreamGetter.layers()
+// GetImageStreamTag assembles the ImageStreamTag of the tag from the image
+// stream and the latest image of the tag, like the master API serves it. The
+// image is retrieved like by GetImageOfImageStream. If the tag does not point
+// to an image, a not found error is returned.
+func (is *imageStream) GetImageStreamTag(ctx context.Context, tag string) (*imageapiv1.ImageStreamTag, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
 	if rErr != nil {
-		return reference.DockerImageReference{}, rerrors.NewError(
-			ErrImageStreamUnknownErrorCode,
-			fmt.Sprintf("resolveUpstreamRef: failed to get layers for image stream %s", is.Reference()),
-			rErr,
-		)
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("GetImageStreamTag: failed to get image stream %s", is.Reference()))
 	}
 
-	parent := ""
-	for image, ibr := range layers.Images {
-		found := false
-		for _, m := range ibr.Manifests {
-			if m == dgst.String() {
-				found = true
-				break
-			}
-		}
-		if found {
-			parent = image
-			break
-		}
-	}
-	if parent == "" {
-		return reference.DockerImageReference{}, rerrors.NewError(
+	event := util.LatestTaggedImage(stream, tag)
+	if event == nil {
+		return nil, rerrors.NewError(
 			ErrImageStreamImageNotFoundCode,
-			fmt.Sprintf("resolveUpstreamRef: unable to find parent for image %s in image stream %s", dgst.String(), is.Reference()),
+			fmt.Sprintf("GetImageStreamTag: unable to find tag %s in image stream %s", tag, is.Reference()),
 			nil,
 		)
 	}
 
-	parentTagEvent, rErr := is.ResolveImageID(ctx, digest.Digest(parent))
-	if rErr != nil {
-		return reference.DockerImageReference{}, rerrors.NewError(
-			ErrImageStreamUnknownErrorCode,
-			fmt.Sprintf("resolveUpstreamRef: unable to get parent event %s in image stream %s", parent, is.Reference()),
-			rErr,
-		)
-	}
-
-	ref, err := reference.Parse(parentTagEvent.DockerImageReference)
+	dgst, err := digest.Parse(event.Image)
 	if err != nil {
-		return reference.DockerImageReference{}, rerrors.NewError(
+		return nil, rerrors.NewError(
 			ErrImageStreamUnknownErrorCode,
-			fmt.Sprintf("resolveUpstreamRef: unable to parse parent image reference %s in image stream %s", parentTagEvent.DockerImageReference, is.Reference()),
+			fmt.Sprintf("GetImageStreamTag: bad digest %s of tag %s in image stream %s", event.Image, tag, is.Reference()),
 			err,
 		)
 	}
 
-	ref.Tag = ""
-	ref.ID = dgst.String()
+	image, rErr := is.GetImageOfImageStream(ctx, dgst)
+	if rErr != nil {
+		return nil, rErr
+	}
+
+	istag := &imageapiv1.ImageStreamTag{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         stream.Namespace,
+			Name:              imageapi.JoinImageStreamTag(stream.Name, tag),
+			CreationTimestamp: event.Created,
+		},
+		Generation:   event.Generation,
+		LookupPolicy: stream.Spec.LookupPolicy,
+		Image:        *image.DeepCopy(),
+	}
+	for _, t := range stream.Spec.Tags {
+		if t.Name == tag {
+			istag.Tag = t.DeepCopy()
+			break
+		}
+	}
+	for _, history := range stream.Status.Tags {
+		if history.Tag == tag {
+			for _, condition := range history.Conditions {
+				istag.Conditions = append(istag.Conditions, *condition.DeepCopy())
+			}
+			break
+		}
+	}
 
-	return ref, nil
+	return istag, nil
 }
 
-func (is *imageStream) GetSecrets() ([]corev1.Secret, rerrors.Error) {
-	secrets, err := is.registryOSClient.ImageStreamSecrets(is.namespace).Secrets(context.TODO(), is.name, metav1.GetOptions{})
+// GetImageIfAllowed is like GetImageOfImageStream, but it returns an error
+// with the code ErrImageStreamForbiddenCode if the image's
+// DockerImageReference points to a registry that is not in
+// allowedRegistries. Images served by the integrated registry are always
+// allowed. References without a registry are matched against docker.io.
+func (is *imageStream) GetImageIfAllowed(ctx context.Context, dgst digest.Digest, allowedRegistries []string) (_ *imageapiv1.Image, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	image, _, rErr := is.getImageWithSource(ctx, dgst)
+	if rErr != nil {
+		return nil, rErr
+	}
+
+	ref, err := reference.Parse(image.DockerImageReference)
 	if err != nil {
 		return nil, rerrors.NewError(
 			ErrImageStreamUnknownErrorCode,
-			fmt.Sprintf("GetSecrets: error getting secrets for repository %s", is.Reference()),
+			fmt.Sprintf("GetImageIfAllowed: unable to parse reference %q of image %s in image stream %s", image.DockerImageReference, dgst.String(), is.Reference()),
 			err,
 		)
 	}
-	return secrets.Items, nil
+	registry := ref.DockerClientDefaults().Registry
+
+	localRegistry, _ := is.localRegistry(ctx)
+	if stringListContains(localRegistry, ref.Registry) || stringListContains(allowedRegistries, registry) {
+		return image, nil
+	}
+
+	return nil, rerrors.NewError(
+		ErrImageStreamForbiddenCode,
+		fmt.Sprintf("GetImageIfAllowed: image %s in image stream %s comes from registry %s, which is not allowed", dgst.String(), is.Reference(), registry),
+		nil,
+	)
 }
 
-// TagIsInsecure returns true if the given image stream or its tag allow for
-// insecure transport.
-func (is *imageStream) TagIsInsecure(ctx context.Context, tag string, dgst digest.Digest) (bool, rerrors.Error) {
-	stream, err := is.imageStreamGetter.get()
+func (is *imageStream) getRewrittenImageOfImageStream(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, ImageSource, rerrors.Error) {
+	ref, source, rewrite, err := is.lookupImageReference(ctx, dgst)
 	if err != nil {
-		return false, convertImageStreamGetterError(err, fmt.Sprintf("TagIsInsecure: failed to get image stream %s", is.Reference()))
+		return nil, "", err
 	}
 
-	if insecure := stream.Annotations[imageapiv1.InsecureRepositoryAnnotation]; insecure == "true" {
-		return true, nil
+	image, err := is.getImage(ctx, dgst)
+	if err != nil {
+		return nil, "", err
 	}
 
-	if len(tag) == 0 {
-		// if the client pulled by digest, find the corresponding tag in the image stream
-		tag, _ = util.LatestImageTagEvent(stream, dgst.String())
+	if !rewrite {
+		ref = image.DockerImageReference
 	}
+	return imageWithReference(image, ref), source, nil
+}
 
-	if len(tag) != 0 {
-		for _, t := range stream.Spec.Tags {
-			if t.Name == tag {
-				return t.ImportPolicy.Insecure, nil
-			}
-		}
+// lookupImageReference finds the image with the given digest in the image
+// stream and returns the DockerImageReference that GetImageOfImageStream
+// reports for it, and how the image has been found. The image is looked up
+// in the tag histories, then as a sub-manifest of a manifest list in the
+// image stream. Images that are only listed by the image stream layers, as
+// their tag events have been pruned from the history, keep their own
+// reference, which is indicated by rewrite being false.
+func (is *imageStream) lookupImageReference(ctx context.Context, dgst digest.Digest) (ref string, source ImageSource, rewrite bool, err rerrors.Error) {
+	tagEvent, err := is.resolveImageID(ctx, dgst)
+	if err == nil {
+		return tagEvent.DockerImageReference, ImageSourceLocal, true, nil
 	}
 
-	return false, nil
-}
+	upstreamRef, err := is.resolveUpstreamRef(ctx, dgst)
+	if err == nil {
+		return upstreamRef.String(), ImageSourceUpstream, true, nil
+	}
 
-func (is *imageStream) Exists(ctx context.Context) (bool, rerrors.Error) {
-	_, rErr := is.imageStreamGetter.get()
-	if rErr != nil {
-		if rErr.Code() == ErrImageStreamGetterNotFoundCode {
-			return false, nil
-		}
-		return false, convertImageStreamGetterError(rErr, fmt.Sprintf("Exists: failed to get image stream %s", is.Reference()))
+	if err.Code() == ErrImageStreamImageNotFoundCode && is.hasTopLevelImage(ctx, dgst) {
+		dcontext.GetLogger(ctx).Debugf("image %s is not in the history of image stream %s, resolving it using the image stream layers", dgst.String(), is.Reference())
+		return "", ImageSourceLocal, false, nil
 	}
-	return true, nil
+
+	return "", "", false, err
 }
 
-func (is *imageStream) localRegistry(ctx context.Context) ([]string, rerrors.Error) {
-	stream, rErr := is.imageStreamGetter.get()
-	if rErr != nil {
-		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("localRegistry: failed to get image stream %s", is.Reference()))
+// hasTopLevelImage returns true if the image stream layers list the image with
+// the given digest as an image of the image stream.
+func (is *imageStream) hasTopLevelImage(ctx context.Context, dgst digest.Digest) bool {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return false
 	}
+	_, ok := layers.Images[dgst.String()]
+	return ok
+}
 
-	var localNames []string
+// ImageInStream returns true if the image with the given digest belongs to
+// the image stream, i.e. if it is in the history of a tag, if it is a
+// sub-manifest of a manifest list in the image stream or if the image stream
+// layers list it. Unlike GetImageOfImageStream, the image itself is not
+// fetched.
+func (is *imageStream) ImageInStream(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error) {
+	_, _, _, rErr := is.lookupImageReference(ctx, dgst)
+	if rErr == nil {
+		return true, nil
+	}
+	switch rErr.Code() {
+	case ErrImageStreamImageNotFoundCode, ErrImageStreamLayersUnsupportedCode:
+		return false, nil
+	}
+	return false, rErr
+}
 
-	local, err := reference.Parse(stream.Status.DockerImageRepository)
+// RewriteReference returns a copy of image whose field DockerImageReference
+// is modified in the same way as by GetImageOfImageStream. The image is
+// expected to have the digest dgst. It allows to apply the modification to
+// an image the caller already has without fetching it again.
+//
+// If the digest is not part of the image stream, a not found error is
+// returned.
+func (is *imageStream) RewriteReference(ctx context.Context, image *imageapiv1.Image, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	ref, _, rewrite, err := is.lookupImageReference(ctx, dgst)
 	if err != nil {
-		dcontext.GetLogger(ctx).Warnf("localRegistry: unable to parse dockerImageRepository %q", stream.Status.DockerImageRepository)
+		return nil, err
 	}
-	if len(local.Registry) != 0 {
-		localNames = append(localNames, local.Registry)
+
+	if !rewrite {
+		ref = image.DockerImageReference
 	}
+	return imageWithReference(image, ref), nil
+}
 
-	if len(stream.Status.PublicDockerImageRepository) > 0 {
-		public, err := reference.Parse(stream.Status.PublicDockerImageRepository)
-		if err != nil {
-			dcontext.GetLogger(ctx).Warnf("localRegistry: unable to parse publicDockerImageRepository %q", stream.Status.PublicDockerImageRepository)
-		}
-		if len(public.Registry) != 0 {
-			localNames = append(localNames, public.Registry)
-		}
+// GetImageByTagWithPolicy retrieves the image the tag points to. The image's
+// field DockerImageReference is modified to match the tag's
+// DockerImageReference, i.e. the reference the image was tagged from. If
+// honorLocalPolicy is true and the tag uses the Local reference policy, the
+// reference points to the image stream in the integrated registry instead.
+func (is *imageStream) GetImageByTagWithPolicy(ctx context.Context, tag string, honorLocalPolicy bool) (*imageapiv1.Image, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("GetImageByTagWithPolicy: failed to get image stream %s", is.Reference()))
 	}
 
-	return localNames, nil
-}
+	tagEvent := util.LatestTaggedImage(stream, tag)
+	if tagEvent == nil {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("GetImageByTagWithPolicy: unable to find tag %s in image stream %s", tag, is.Reference()),
+			nil,
+		)
+	}
 
-func (is *imageStream) IdentifyCandidateRepositories(ctx context.Context, primary bool) ([]string, map[string]ImagePullthroughSpec, rerrors.Error) {
-	stream, err := is.imageStreamGetter.get()
+	dgst, err := digest.Parse(tagEvent.Image)
 	if err != nil {
-		return nil, nil, convertImageStreamGetterError(err, fmt.Sprintf("IdentifyCandidateRepositories: failed to get image stream %s", is.Reference()))
+		return nil, rerrors.NewError(
+			ErrImageStreamUnknownErrorCode,
+			fmt.Sprintf("GetImageByTagWithPolicy: bad digest %s of tag %s in image stream %s", tagEvent.Image, tag, is.Reference()),
+			err,
+		)
 	}
 
-	localRegistry, _ := is.localRegistry(ctx)
+	image, rErr := is.getImage(ctx, dgst)
+	if rErr != nil {
+		return nil, rErr
+	}
 
-	repositoryCandidates, search := identifyCandidateRepositories(stream, localRegistry, primary)
-	return repositoryCandidates, search, nil
-}
+	img := imageWithReference(image, tagEvent.DockerImageReference)
 
-func (is *imageStream) Tags(ctx context.Context) (map[string]digest.Digest, rerrors.Error) {
-	stream, err := is.imageStreamGetter.get()
-	if err != nil {
-		return nil, convertImageStreamGetterError(err, fmt.Sprintf("Tags: failed to get image stream %s", is.Reference()))
+	if honorLocalPolicy && len(stream.Status.DockerImageRepository) != 0 {
+		for _, t := range stream.Spec.Tags {
+			if t.Name == tag && t.ReferencePolicy.Type == imageapiv1.LocalTagReferencePolicy {
+				img.DockerImageReference = stream.Status.DockerImageRepository + "@" + dgst.String()
+				break
+			}
+		}
 	}
 
-	m := make(map[string]digest.Digest)
+	return img, nil
+}
 
-	for _, history := range stream.Status.Tags {
+// ResolveAllTags returns the images the tags of the image stream point to.
+// As with GetImageOfImageStream, the images' field DockerImageReference is
+// modified to match the tag's DockerImageReference.
+//
+// The image stream is fetched once and each distinct image is fetched only
+// once even if it is shared by several tags. The images are fetched
+// concurrently, see WithImageFetchConcurrency. Tags whose images no longer
+// exist are omitted from the result, other errors are returned.
+func (is *imageStream) ResolveAllTags(ctx context.Context) (map[string]*imageapiv1.Image, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("ResolveAllTags: failed to get image stream %s", is.Reference()))
+	}
+
+	tagEvents := make(map[string]*imageapiv1.TagEvent)
+	tagDigests := make(map[string]digest.Digest)
+	seen := make(map[digest.Digest]bool)
+	var dgsts []digest.Digest
+	for i, history := range stream.Status.Tags {
 		if len(history.Items) == 0 {
 			continue
 		}
 
-		tag := history.Tag
+		tagEvent := &stream.Status.Tags[i].Items[0]
 
-		dgst, err := digest.Parse(history.Items[0].Image)
+		dgst, err := digest.Parse(tagEvent.Image)
 		if err != nil {
-			dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", history.Items[0].Image, err)
+			dcontext.GetLogger(ctx).Errorf("ResolveAllTags: bad digest %s of tag %s: %v", tagEvent.Image, history.Tag, err)
 			continue
 		}
 
-		m[tag] = dgst
+		tagEvents[history.Tag] = tagEvent
+		tagDigests[history.Tag] = dgst
+		if !seen[dgst] {
+			seen[dgst] = true
+			dgsts = append(dgsts, dgst)
+		}
 	}
 
-	return m, nil
-}
-
-func (is *imageStream) CreateImageStreamMapping(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) rerrors.Error {
-	ism := imageapiv1.ImageStreamMapping{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: is.namespace,
-			Name:      is.name,
-		},
-		Image: *image,
-		Tag:   tag,
+	images, rErr := is.getImages(ctx, dgsts)
+	if rErr != nil {
+		return nil, rErr
 	}
 
-	_, err := is.registryOSClient.ImageStreamMappings(is.namespace).Create(ctx, &ism, metav1.CreateOptions{})
+	result := make(map[string]*imageapiv1.Image)
+	for tag, dgst := range tagDigests {
+		image, ok := images[dgst]
+		if !ok {
+			dcontext.GetLogger(ctx).Warnf("ResolveAllTags: skipping tag %s: image %s cannot be retrieved", tag, dgst.String())
+			continue
+		}
 
-	if err == nil {
-		return nil
+		result[tag] = imageWithReference(image, tagEvents[tag].DockerImageReference)
+	}
+
+	return result, nil
+}
+
+// UpstreamReference returns a reference that can be used to pull the image
+// with the given digest from the upstream repository. The image can be either
+// a tagged image that has a history entry in the image stream or a
+// sub-manifest of a tagged manifest list. The returned bool is true if the
+// reference was resolved for a sub-manifest.
+func (is *imageStream) UpstreamReference(ctx context.Context, dgst digest.Digest) (reference.DockerImageReference, bool, rerrors.Error) {
+	tagEvent, rErr := is.resolveImageID(ctx, dgst)
+	if rErr != nil {
+		if rErr.Code() != ErrImageStreamImageNotFoundCode {
+			return reference.DockerImageReference{}, false, rErr
+		}
+
+		ref, rErr := is.resolveUpstreamRef(ctx, dgst)
+		if rErr != nil {
+			return reference.DockerImageReference{}, false, rErr
+		}
+		return ref, true, nil
+	}
+
+	ref, err := reference.Parse(tagEvent.DockerImageReference)
+	if err != nil {
+		return reference.DockerImageReference{}, false, rerrors.NewError(
+			ErrImageStreamUnknownErrorCode,
+			fmt.Sprintf("UpstreamReference: unable to parse image reference %s in image stream %s", tagEvent.DockerImageReference, is.Reference()),
+			err,
+		)
+	}
+
+	ref.Tag = ""
+	ref.ID = dgst.String()
+
+	return ref, false, nil
+}
+
+// resolveUpstreamRef returns an image reference for an image with the given
+// digest that can be used to pull the image from the upstream repository.
+//
+// It uses the image layers API to find the parent image, and then finds the
+// upstream repository for the parent image in the image stream. If the parent
+// is a manifest list nested in another manifest list, the parents are walked
+// through until one with a history entry is found, but at most
+// maxManifestListDepth levels deep.
+//
+// It works only for sub-manifests, for which the image stream usually does not
+// have a history entry. For the main manifest, the image stream should have a
+// history entry that can be found by ResolveImageID.
+func (is *imageStream) resolveUpstreamRef(ctx context.Context, dgst digest.Digest) (reference.DockerImageReference, rerrors.Error) {
+	layers, rErr := is.imageStreamGetter.Layers(ctx)
+	if rErr != nil {
+		code := ErrImageStreamUnknownErrorCode
+		if rErr.Code() == ErrImageStreamGetterLayersUnsupportedCode {
+			code = ErrImageStreamLayersUnsupportedCode
+		}
+		return reference.DockerImageReference{}, rerrors.NewError(
+			code,
+			fmt.Sprintf("resolveUpstreamRef: failed to get layers for image stream %s", is.Reference()),
+			rErr,
+		)
+	}
+
+	child := dgst.String()
+	for depth := 1; ; depth++ {
+		if depth > is.maxManifestListDepth {
+			return reference.DockerImageReference{}, rerrors.NewError(
+				ErrImageStreamImageNotFoundCode,
+				fmt.Sprintf("resolveUpstreamRef: image %s in image stream %s is nested in more than %d manifest lists", dgst.String(), is.Reference(), is.maxManifestListDepth),
+				nil,
+			)
+		}
+
+		parent := findParentManifest(layers, child)
+		if parent == "" {
+			return reference.DockerImageReference{}, rerrors.NewError(
+				ErrImageStreamImageNotFoundCode,
+				fmt.Sprintf("resolveUpstreamRef: unable to find parent for image %s in image stream %s", child, is.Reference()),
+				nil,
+			)
+		}
+
+		parentTagEvent, rErr := is.resolveImageID(ctx, digest.Digest(parent))
+		if rErr != nil {
+			if rErr.Code() == ErrImageStreamImageNotFoundCode {
+				// the parent may be a sub-manifest itself
+				child = parent
+				continue
+			}
+			return reference.DockerImageReference{}, rerrors.NewError(
+				ErrImageStreamUnknownErrorCode,
+				fmt.Sprintf("resolveUpstreamRef: unable to get parent event %s in image stream %s", parent, is.Reference()),
+				rErr,
+			)
+		}
+
+		ref, err := reference.Parse(parentTagEvent.DockerImageReference)
+		if err != nil {
+			return reference.DockerImageReference{}, rerrors.NewError(
+				ErrImageStreamUnknownErrorCode,
+				fmt.Sprintf("resolveUpstreamRef: unable to parse parent image reference %s in image stream %s", parentTagEvent.DockerImageReference, is.Reference()),
+				err,
+			)
+		}
+
+		ref.Tag = ""
+		ref.ID = dgst.String()
+
+		return ref, nil
+	}
+}
+
+// findParentManifest returns the digest of the manifest list that references
+// the manifest dgst, or an empty string if there is no such manifest list.
+func findParentManifest(layers *imageapiv1.ImageStreamLayers, dgst string) string {
+	for image, ibr := range layers.Images {
+		for _, m := range ibr.Manifests {
+			if m == dgst {
+				return image
+			}
+		}
+	}
+	return ""
+}
+
+func (is *imageStream) GetSecrets() (_ []corev1.Secret, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(context.TODO(), resultErr) }()
+
+	secrets, err := is.registryOSClient.ImageStreamSecrets(is.namespace).Secrets(context.TODO(), is.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, rerrors.NewError(
+			ErrImageStreamUnknownErrorCode,
+			fmt.Sprintf("GetSecrets: error getting secrets for repository %s", is.Reference()),
+			err,
+		)
+	}
+	return secrets.Items, nil
+}
+
+// InvalidSecrets returns the names of the image stream's secrets whose docker
+// configuration cannot be parsed. Such secrets are unusable for pullthrough.
+func (is *imageStream) InvalidSecrets(ctx context.Context) ([]string, rerrors.Error) {
+	secrets, err := is.GetSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return InvalidSecretNames(secrets), nil
+}
+
+// InvalidSecretNames returns the names of the secrets whose docker
+// configuration cannot be parsed.
+func InvalidSecretNames(secrets []corev1.Secret) []string {
+	var names []string
+	for _, secret := range secrets {
+		if _, err := credentialprovider.MakeDockerKeyring([]corev1.Secret{secret}, &credentialprovider.BasicDockerKeyring{}); err != nil {
+			names = append(names, secret.Name)
+		}
+	}
+	return names
+}
+
+// TagIsInsecure returns true if the given image stream or its tag allow for
+// insecure transport.
+func (is *imageStream) TagIsInsecure(ctx context.Context, tag string, dgst digest.Digest) (_ bool, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return false, convertImageStreamGetterError(err, fmt.Sprintf("TagIsInsecure: failed to get image stream %s", is.Reference()))
+	}
+
+	if insecure := stream.Annotations[imageapiv1.InsecureRepositoryAnnotation]; insecure == "true" {
+		return true, nil
+	}
+
+	if len(tag) == 0 {
+		// if the client pulled by digest, find the corresponding tag in the image stream
+		tag, _ = util.LatestImageTagEvent(stream, dgst.String())
+	}
+
+	return specTagIsInsecure(stream, tag), nil
+}
+
+// DigestIsInsecure returns true if the image with the given digest may be
+// pulled over an insecure connection. It is meant for pulls by digest: the
+// insecure flag is taken from the import policy of the tag whose history
+// contains the digest most recently. An image stream that allows insecure
+// transport as a whole does so for every digest.
+func (is *imageStream) DigestIsInsecure(ctx context.Context, dgst digest.Digest) (_ bool, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return false, convertImageStreamGetterError(err, fmt.Sprintf("DigestIsInsecure: failed to get image stream %s", is.Reference()))
+	}
+
+	if insecure := stream.Annotations[imageapiv1.InsecureRepositoryAnnotation]; insecure == "true" {
+		return true, nil
+	}
+
+	tag, event := util.LatestImageTagEvent(stream, dgst.String())
+	if event == nil {
+		dcontext.GetLogger(ctx).Debugf("DigestIsInsecure: no tag of image stream %s references %s", is.Reference(), dgst)
+		return false, nil
+	}
+
+	return specTagIsInsecure(stream, tag), nil
+}
+
+// specTagIsInsecure returns the insecure flag of the import policy of the
+// spec tag, or false if the image stream has no such spec tag.
+func specTagIsInsecure(stream *imageapiv1.ImageStream, tag string) bool {
+	if len(tag) == 0 {
+		return false
+	}
+	for _, t := range stream.Spec.Tags {
+		if t.Name == tag {
+			return t.ImportPolicy.Insecure
+		}
+	}
+	return false
+}
+
+// IsLocalOnly returns true if every tag of the image stream uses the Local
+// reference policy, i.e. the images should be served only by the integrated
+// registry and pullthrough is never needed. Tags that have a history but no
+// spec tag use the default Source policy.
+func (is *imageStream) IsLocalOnly(ctx context.Context) (bool, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return false, convertImageStreamGetterError(err, fmt.Sprintf("IsLocalOnly: failed to get image stream %s", is.Reference()))
+	}
+
+	if len(stream.Spec.Tags) == 0 && len(stream.Status.Tags) == 0 {
+		return false, nil
+	}
+
+	specTags := make(map[string]bool)
+	for _, t := range stream.Spec.Tags {
+		if t.ReferencePolicy.Type != imageapiv1.LocalTagReferencePolicy {
+			return false, nil
+		}
+		specTags[t.Name] = true
+	}
+
+	for _, history := range stream.Status.Tags {
+		if !specTags[history.Tag] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// LookupPolicyLocal returns true if the image stream's lookup policy allows
+// image references to be resolved to the integrated registry.
+func (is *imageStream) LookupPolicyLocal(ctx context.Context) (bool, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return false, convertImageStreamGetterError(err, fmt.Sprintf("LookupPolicyLocal: failed to get image stream %s", is.Reference()))
+	}
+	return stream.Spec.LookupPolicy.Local, nil
+}
+
+// StreamCreated returns the creation timestamp of the image stream.
+func (is *imageStream) StreamCreated(ctx context.Context) (metav1.Time, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return metav1.Time{}, convertImageStreamGetterError(err, fmt.Sprintf("StreamCreated: failed to get image stream %s", is.Reference()))
+	}
+	return stream.CreationTimestamp, nil
+}
+
+// StableID returns an identifier of the image stream in the form
+// namespace/name/uid. Unlike Reference, it changes when the image stream is
+// deleted and created again with the same name.
+func (is *imageStream) StableID(ctx context.Context) (string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return "", convertImageStreamGetterError(err, fmt.Sprintf("StableID: failed to get image stream %s", is.Reference()))
+	}
+	return fmt.Sprintf("%s/%s/%s", is.namespace, is.name, stream.UID), nil
+}
+
+// SpecTags returns a copy of the spec tags of the image stream in the order
+// they are defined.
+func (is *imageStream) SpecTags(ctx context.Context) ([]imageapiv1.TagReference, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("SpecTags: failed to get image stream %s", is.Reference()))
+	}
+
+	tags := make([]imageapiv1.TagReference, len(stream.Spec.Tags))
+	for i := range stream.Spec.Tags {
+		stream.Spec.Tags[i].DeepCopyInto(&tags[i])
+	}
+	return tags, nil
+}
+
+// TagReferencePolicies returns the reference policy types of the spec tags.
+// Tags without an explicit policy use the Source policy.
+func (is *imageStream) TagReferencePolicies(ctx context.Context) (map[string]imageapiv1.TagReferencePolicyType, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("TagReferencePolicies: failed to get image stream %s", is.Reference()))
+	}
+
+	result := make(map[string]imageapiv1.TagReferencePolicyType, len(stream.Spec.Tags))
+	for _, t := range stream.Spec.Tags {
+		policy := t.ReferencePolicy.Type
+		if len(policy) == 0 {
+			policy = imageapiv1.SourceTagReferencePolicy
+		}
+		result[t.Name] = policy
+	}
+	return result, nil
+}
+
+// ScheduledTags returns the sorted names of the spec tags that are
+// periodically imported from their sources.
+func (is *imageStream) ScheduledTags(ctx context.Context) ([]string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("ScheduledTags: failed to get image stream %s", is.Reference()))
+	}
+
+	tags := []string{}
+	for _, t := range stream.Spec.Tags {
+		if t.ImportPolicy.Scheduled {
+			tags = append(tags, t.Name)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// ImportPolicySummary returns the number of spec tags of the image stream
+// that are scheduled, insecure and that use the local reference policy. A tag
+// is insecure if its import policy says so or if the whole image stream is
+// annotated as insecure.
+func (is *imageStream) ImportPolicySummary(ctx context.Context) (ImportPolicySummary, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return ImportPolicySummary{}, convertImageStreamGetterError(err, fmt.Sprintf("ImportPolicySummary: failed to get image stream %s", is.Reference()))
+	}
+
+	insecureByDefault := stream.Annotations[imageapiv1.InsecureRepositoryAnnotation] == "true"
+
+	summary := ImportPolicySummary{Tags: len(stream.Spec.Tags)}
+	for _, t := range stream.Spec.Tags {
+		if t.ImportPolicy.Scheduled {
+			summary.Scheduled++
+		}
+		if insecureByDefault || t.ImportPolicy.Insecure {
+			summary.Insecure++
+		}
+		if t.ReferencePolicy.Type == imageapiv1.LocalTagReferencePolicy {
+			summary.LocalReferencePolicy++
+		}
+	}
+	return summary, nil
+}
+
+// TagLastImport returns for each tag the time its latest image was recorded
+// in the image stream. Tags without images are omitted.
+func (is *imageStream) TagLastImport(ctx context.Context) (map[string]metav1.Time, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("TagLastImport: failed to get image stream %s", is.Reference()))
+	}
+
+	result := make(map[string]metav1.Time)
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		result[history.Tag] = history.Items[0].Created
+	}
+	return result, nil
+}
+
+// TagsPushedSince returns the tags whose latest images have been recorded in
+// the image stream after since, mapped to the images' digests.
+func (is *imageStream) TagsPushedSince(ctx context.Context, since time.Time) (map[string]digest.Digest, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("TagsPushedSince: failed to get image stream %s", is.Reference()))
+	}
+
+	result := make(map[string]digest.Digest)
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 || !history.Items[0].Created.Time.After(since) {
+			continue
+		}
+		dgst, err := digest.Parse(history.Items[0].Image)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", history.Items[0].Image, err)
+			continue
+		}
+		result[history.Tag] = dgst
+	}
+	return result, nil
+}
+
+// ResolveLatestOrNewest returns the latest TagEvent of the "latest" tag and
+// the name of the tag. If the image stream has no "latest" tag, the tag with
+// the most recently created TagEvent is used instead. If several tags have
+// been updated at the same time, the first one in alphabetical order is
+// picked.
+func (is *imageStream) ResolveLatestOrNewest(ctx context.Context) (*imageapiv1.TagEvent, string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, "", convertImageStreamGetterError(err, fmt.Sprintf("ResolveLatestOrNewest: failed to get image stream %s", is.Reference()))
+	}
+
+	if event := util.LatestTaggedImage(stream, imageapiv1.DefaultImageTag); event != nil {
+		return event, imageapiv1.DefaultImageTag, nil
+	}
+
+	var newest *imageapiv1.TagEvent
+	var newestTag string
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		event := &history.Items[0]
+		if newest == nil || event.Created.After(newest.Created.Time) ||
+			(event.Created.Equal(&newest.Created) && history.Tag < newestTag) {
+			newest = event
+			newestTag = history.Tag
+		}
+	}
+	if newest == nil {
+		return nil, "", rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("ResolveLatestOrNewest: image stream %s has no tagged images", is.Reference()),
+			nil,
+		)
+	}
+	return newest, newestTag, nil
+}
+
+// ResolveTagAt returns the TagEvent the tag pointed to at the given time,
+// i.e. the most recent TagEvent of the tag that was created not later than
+// at. If the tag did not exist at that time, a not found error is returned.
+func (is *imageStream) ResolveTagAt(ctx context.Context, tag string, at time.Time) (*imageapiv1.TagEvent, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("ResolveTagAt: failed to get image stream %s", is.Reference()))
+	}
+
+	for _, history := range stream.Status.Tags {
+		if history.Tag != tag {
+			continue
+		}
+		// the items are ordered from the newest to the oldest one
+		for i := range history.Items {
+			if !history.Items[i].Created.Time.After(at) {
+				return &history.Items[i], nil
+			}
+		}
+		break
+	}
+
+	return nil, rerrors.NewError(
+		ErrImageStreamImageNotFoundCode,
+		fmt.Sprintf("ResolveTagAt: tag %s in image stream %s has no image created before %s", tag, is.Reference(), at.Format(time.RFC3339)),
+		nil,
+	)
+}
+
+// Generation returns the generation of the image stream and the generation
+// the image stream controller has observed.
+//
+// Image streams have no status field for the observed generation, the
+// controller records it for each tag instead. The observed generation is
+// therefore the highest generation found in the latest tag events and the
+// tag conditions. The image stream has been processed when it is not lower
+// than the metadata generation.
+func (is *imageStream) Generation(ctx context.Context) (meta, observed int64, rErr rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return 0, 0, convertImageStreamGetterError(err, fmt.Sprintf("Generation: failed to get image stream %s", is.Reference()))
+	}
+
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) > 0 && history.Items[0].Generation > observed {
+			observed = history.Items[0].Generation
+		}
+		for _, condition := range history.Conditions {
+			if condition.Generation > observed {
+				observed = condition.Generation
+			}
+		}
+	}
+
+	return stream.Generation, observed, nil
+}
+
+// AllowsAnonymousPull returns true if anonymous users are allowed to pull
+// images from the image stream. There is no annotation or spec field for this:
+// as for any other user, it is decided by the RBAC policy. The master API is
+// asked with a local subject access review whether an unauthenticated user may
+// get the layers of the image stream, which is the permission the registry
+// checks on pulls. The review is sent at most once, its answer is cached for
+// the lifetime of the ImageStream. Without a master API client anonymous pulls
+// are not allowed.
+func (is *imageStream) AllowsAnonymousPull(ctx context.Context) (bool, rerrors.Error) {
+	is.anonymousPull.mu.Lock()
+	defer is.anonymousPull.mu.Unlock()
+
+	if is.anonymousPull.allowed != nil {
+		return *is.anonymousPull.allowed, nil
+	}
+
+	if is.registryOSClient == nil {
+		dcontext.GetLogger(ctx).Debugf("AllowsAnonymousPull: no client to check access to image stream %s", is.Reference())
+		return false, nil
+	}
+
+	lsar := &authorizationapi.LocalSubjectAccessReview{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: is.namespace,
+		},
+		Spec: authorizationapi.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationapi.ResourceAttributes{
+				Namespace: is.namespace,
+				Verb:      "get",
+				Group:     imageapiv1.GroupName,
+				Resource:  "imagestreams/layers",
+				Name:      is.name,
+			},
+			User:   user.Anonymous,
+			Groups: []string{user.AllUnauthenticated},
+		},
+	}
+	response, err := is.registryOSClient.LocalSubjectAccessReviews(is.namespace).Create(ctx, lsar, metav1.CreateOptions{})
+	if err != nil {
+		code := ErrImageStreamUnknownErrorCode
+		if kerrors.IsUnauthorized(err) || kerrors.IsForbidden(err) {
+			code = ErrImageStreamForbiddenCode
+		}
+		return false, is.redact(ctx, rerrors.NewError(
+			code,
+			fmt.Sprintf("AllowsAnonymousPull: failed to check access to image stream %s", is.Reference()),
+			err,
+		))
+	}
+
+	allowed := response.Status.Allowed
+	is.anonymousPull.allowed = &allowed
+	return allowed, nil
+}
+
+// TriggerAnnotations returns the raw value of the image change triggers
+// annotation of the image stream and whether the annotation is set.
+func (is *imageStream) TriggerAnnotations(ctx context.Context) (string, bool, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return "", false, convertImageStreamGetterError(err, fmt.Sprintf("TriggerAnnotations: failed to get image stream %s", is.Reference()))
+	}
+	triggers, ok := stream.Annotations[TriggersAnnotation]
+	return triggers, ok, nil
+}
+
+func (is *imageStream) Exists(ctx context.Context) (_ bool, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	_, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		if rErr.Code() == ErrImageStreamGetterNotFoundCode {
+			return false, nil
+		}
+		return false, convertImageStreamGetterError(rErr, fmt.Sprintf("Exists: failed to get image stream %s", is.Reference()))
+	}
+	return true, nil
+}
+
+// ExistsAndWritable returns whether the image stream exists and whether the
+// user of userClient is allowed to create image stream mappings in it, i.e.
+// to push images into it. The permission is checked even if the image stream
+// does not exist, as it may be auto provisioned by the push.
+func (is *imageStream) ExistsAndWritable(ctx context.Context, userClient client.Interface) (exists bool, writable bool, rErr rerrors.Error) {
+	exists, rErr = is.Exists(ctx)
+	if rErr != nil {
+		return false, false, rErr
+	}
+
+	sar := &authorizationapi.SelfSubjectAccessReview{
+		Spec: authorizationapi.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationapi.ResourceAttributes{
+				Namespace: is.namespace,
+				Verb:      "create",
+				Group:     imageapiv1.GroupName,
+				Resource:  "imagestreammappings",
+				Name:      is.name,
+			},
+		},
+	}
+	response, err := userClient.SelfSubjectAccessReviews().Create(ctx, sar, metav1.CreateOptions{})
+	if err != nil {
+		if kerrors.IsUnauthorized(err) || kerrors.IsForbidden(err) {
+			dcontext.GetLogger(ctx).Debugf("ExistsAndWritable: access review for %s denied: %v", is.Reference(), err)
+			return exists, false, nil
+		}
+		return exists, false, is.redact(ctx, rerrors.NewError(
+			ErrImageStreamUnknownErrorCode,
+			fmt.Sprintf("ExistsAndWritable: failed to check access to image stream %s", is.Reference()),
+			err,
+		))
+	}
+
+	return exists, response.Status.Allowed, nil
+}
+
+func (is *imageStream) localRegistry(ctx context.Context) ([]string, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("localRegistry: failed to get image stream %s", is.Reference()))
+	}
+
+	var localNames []string
+
+	if local := repositoryRegistry(ctx, "dockerImageRepository", stream.Status.DockerImageRepository); len(local) != 0 {
+		localNames = append(localNames, local)
+	}
+
+	if len(stream.Status.PublicDockerImageRepository) > 0 {
+		if public := repositoryRegistry(ctx, "publicDockerImageRepository", stream.Status.PublicDockerImageRepository); len(public) != 0 {
+			localNames = append(localNames, public)
+		}
+	}
+
+	return localNames, nil
+}
+
+// repositoryRegistry returns the registry host of the repository repo. The
+// field is the name of the image stream status field repo comes from, it is
+// used for logging.
+func repositoryRegistry(ctx context.Context, field, repo string) string {
+	ref, err := reference.Parse(repo)
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("unable to parse %s %q of image stream", field, repo)
+	}
+	return ref.Registry
+}
+
+// PublicHost returns the registry host of the public repository of the image
+// stream and whether it is set. It is not set when the integrated registry is
+// not exposed or when the public repository cannot be parsed.
+func (is *imageStream) PublicHost(ctx context.Context) (string, bool, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return "", false, convertImageStreamGetterError(err, fmt.Sprintf("PublicHost: failed to get image stream %s", is.Reference()))
+	}
+
+	if len(stream.Status.PublicDockerImageRepository) == 0 {
+		return "", false, nil
+	}
+	public := repositoryRegistry(ctx, "publicDockerImageRepository", stream.Status.PublicDockerImageRepository)
+	return public, len(public) != 0, nil
+}
+
+// PreferredRegistry returns the registry host that should be used to pull
+// images of the image stream. If external is true, the public host is
+// preferred and the internal one is used only when the public one is not
+// set. Otherwise the internal host is returned.
+func (is *imageStream) PreferredRegistry(ctx context.Context, external bool) (string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return "", convertImageStreamGetterError(err, fmt.Sprintf("PreferredRegistry: failed to get image stream %s", is.Reference()))
+	}
+
+	if external && len(stream.Status.PublicDockerImageRepository) > 0 {
+		if public := repositoryRegistry(ctx, "publicDockerImageRepository", stream.Status.PublicDockerImageRepository); len(public) != 0 {
+			return public, nil
+		}
+	}
+
+	return repositoryRegistry(ctx, "dockerImageRepository", stream.Status.DockerImageRepository), nil
+}
+
+func (is *imageStream) IdentifyCandidateRepositories(ctx context.Context, primary bool) (_ []string, _ map[string]ImagePullthroughSpec, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, nil, convertImageStreamGetterError(err, fmt.Sprintf("IdentifyCandidateRepositories: failed to get image stream %s", is.Reference()))
+	}
+
+	localRegistry, _ := is.localRegistry(ctx)
+
+	repositoryCandidates, search := identifyCandidateRepositories(stream, localRegistry, primary)
+
+	if is.mirrorResolver != nil {
+		repositoryCandidates = is.prependMirrors(ctx, stream, primary, repositoryCandidates, search)
+	}
+
+	if is.candidateRanker != nil {
+		repositoryCandidates = is.rankCandidates(ctx, repositoryCandidates, search)
+	}
+
+	if is.preferPublic {
+		repositoryCandidates = is.prependPublicRepository(ctx, stream, repositoryCandidates, search)
+	}
+
+	return repositoryCandidates, search, nil
+}
+
+// CandidatesForDigest returns the pullthrough candidates of the tag events
+// that carry the image with the given digest, ordered from the best candidate
+// to the worst. The candidates are ordered as by IdentifyCandidateRepositories:
+// the repositories of events that are the latest in their tags come first,
+// and the mirror resolver and the candidate ranker are applied. If no tag
+// references the image, a not found error is returned.
+func (is *imageStream) CandidatesForDigest(ctx context.Context, dgst digest.Digest) ([]ImagePullthroughSpec, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("CandidatesForDigest: failed to get image stream %s", is.Reference()))
+	}
+
+	// owning keeps only the events that carry the image, so the latest of
+	// them is the primary candidate of its tag.
+	owning := *stream
+	owning.Status.Tags = nil
+	for _, history := range stream.Status.Tags {
+		var items []imageapiv1.TagEvent
+		for _, item := range history.Items {
+			if item.Image == dgst.String() {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			owning.Status.Tags = append(owning.Status.Tags, imageapiv1.NamedTagEventList{Tag: history.Tag, Items: items})
+		}
+	}
+	if len(owning.Status.Tags) == 0 {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("CandidatesForDigest: no tag in image stream %s references image %s", is.Reference(), dgst.String()),
+			nil,
+		)
+	}
+
+	localRegistry, _ := is.localRegistry(ctx)
+
+	var repositories []string
+	search := make(map[string]ImagePullthroughSpec)
+	for _, primary := range []bool{true, false} {
+		candidates, specs := identifyCandidateRepositories(&owning, localRegistry, primary)
+		if is.mirrorResolver != nil {
+			candidates = is.prependMirrors(ctx, &owning, primary, candidates, specs)
+		}
+		if is.candidateRanker != nil {
+			candidates = is.rankCandidates(ctx, candidates, specs)
+		}
+		if is.preferPublic {
+			candidates = is.prependPublicRepository(ctx, &owning, candidates, specs)
+		}
+		for _, repo := range candidates {
+			if _, ok := search[repo]; ok {
+				continue
+			}
+			repositories = append(repositories, repo)
+			search[repo] = specs[repo]
+		}
+	}
+
+	result := make([]ImagePullthroughSpec, 0, len(repositories))
+	for _, repo := range repositories {
+		result = append(result, search[repo])
+	}
+	return result, nil
+}
+
+// rankCandidates orders the repository candidates as the candidate ranker
+// returns them. Repositories the ranker drops are removed from the candidates,
+// specs of unknown or duplicate repositories are ignored.
+func (is *imageStream) rankCandidates(ctx context.Context, repositoryCandidates []string, search map[string]ImagePullthroughSpec) []string {
+	specs := make([]ImagePullthroughSpec, 0, len(repositoryCandidates))
+	for _, repo := range repositoryCandidates {
+		specs = append(specs, search[repo])
+	}
+
+	ranked := make([]string, 0, len(specs))
+	for _, spec := range is.candidateRanker(specs) {
+		if spec.DockerImageReference == nil {
+			continue
+		}
+		repo := spec.DockerImageReference.AsRepository().Exact()
+		if _, ok := search[repo]; !ok {
+			dcontext.GetLogger(ctx).Warnf("candidate ranker returned unknown repository %s", repo)
+			continue
+		}
+		if stringListContains(ranked, repo) {
+			continue
+		}
+		ranked = append(ranked, repo)
+	}
+	return ranked
+}
+
+// prependPublicRepository puts the public repository of the image stream, if
+// any, in front of the repository candidates and adds it to search. Its spec
+// has LocalFirst set, as the public repository is served by the integrated
+// registry.
+func (is *imageStream) prependPublicRepository(ctx context.Context, stream *imageapiv1.ImageStream, repositoryCandidates []string, search map[string]ImagePullthroughSpec) []string {
+	if len(stream.Status.PublicDockerImageRepository) == 0 {
+		return repositoryCandidates
+	}
+	ref, err := reference.Parse(stream.Status.PublicDockerImageRepository)
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("unable to parse publicDockerImageRepository %q of image stream", stream.Status.PublicDockerImageRepository)
+		return repositoryCandidates
+	}
+	ref = ref.DockerClientDefaults()
+	public := ref.AsRepository().Exact()
+	search[public] = ImagePullthroughSpec{
+		DockerImageReference: &ref,
+		LocalFirst:           true,
+	}
+
+	result := make([]string, 0, len(repositoryCandidates)+1)
+	result = append(result, public)
+	for _, repo := range repositoryCandidates {
+		if repo != public {
+			result = append(result, repo)
+		}
+	}
+	return result
+}
+
+// prependMirrors adds the mirror repositories the mirror resolver returns for
+// the images considered by IdentifyCandidateRepositories to the front of the
+// repository candidates and to search.
+func (is *imageStream) prependMirrors(ctx context.Context, stream *imageapiv1.ImageStream, primary bool, repositoryCandidates []string, search map[string]ImagePullthroughSpec) []string {
+	var mirrors []string
+	for _, history := range stream.Status.Tags {
+		events := history.Items
+		if primary && len(events) > 1 {
+			events = events[:1]
+		} else if !primary {
+			if len(events) <= 1 {
+				continue
+			}
+			events = events[1:]
+		}
+		for _, event := range events {
+			dgst, err := digest.Parse(event.Image)
+			if err != nil {
+				dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", event.Image, err)
+				continue
+			}
+			ref, ok := is.mirrorResolver(dgst)
+			if !ok {
+				continue
+			}
+			ref = ref.DockerClientDefaults()
+			repo := ref.AsRepository().Exact()
+			if stringListContains(mirrors, repo) {
+				continue
+			}
+			mirrors = append(mirrors, repo)
+			search[repo] = ImagePullthroughSpec{DockerImageReference: &ref}
+		}
+	}
+	if len(mirrors) == 0 {
+		return repositoryCandidates
+	}
+
+	for _, repo := range repositoryCandidates {
+		if !stringListContains(mirrors, repo) {
+			mirrors = append(mirrors, repo)
+		}
+	}
+	return mirrors
+}
+
+// IdentifyCandidateReferences is like IdentifyCandidateRepositories, but the
+// candidates are returned as the references parsed during the identification,
+// in the same order, so that callers do not need to parse them again.
+func (is *imageStream) IdentifyCandidateReferences(ctx context.Context, primary bool) ([]reference.DockerImageReference, map[string]ImagePullthroughSpec, rerrors.Error) {
+	repositoryCandidates, search, rErr := is.IdentifyCandidateRepositories(ctx, primary)
+	if rErr != nil {
+		return nil, nil, rErr
+	}
+
+	refs := make([]reference.DockerImageReference, 0, len(repositoryCandidates))
+	for _, repo := range repositoryCandidates {
+		refs = append(refs, *search[repo].DockerImageReference)
+	}
+	return refs, search, nil
+}
+
+// IdentifyCandidateRepositoriesLocalFirst is like
+// IdentifyCandidateRepositories, but the repository of the image stream in the
+// integrated registry is returned as the first candidate and its spec has
+// LocalFirst set. It allows the pull layer to check local storage before it
+// reaches out to remote registries. If the image stream has no internal
+// repository, the result matches IdentifyCandidateRepositories.
+//
+// With WithPreferPublic, the public repository of the image stream, if any,
+// is returned before the internal one and it is marked LocalFirst too.
+func (is *imageStream) IdentifyCandidateRepositoriesLocalFirst(ctx context.Context, primary bool) ([]string, map[string]ImagePullthroughSpec, rerrors.Error) {
+	repositoryCandidates, search, rErr := is.IdentifyCandidateRepositories(ctx, primary)
+	if rErr != nil {
+		return nil, nil, rErr
+	}
+
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return nil, nil, convertImageStreamGetterError(rErr, fmt.Sprintf("IdentifyCandidateRepositoriesLocalFirst: failed to get image stream %s", is.Reference()))
+	}
+
+	var locals []string
+	addLocal := func(field, repo string) {
+		if len(repo) == 0 {
+			return
+		}
+		ref, err := reference.Parse(repo)
+		if err != nil {
+			dcontext.GetLogger(ctx).Warnf("unable to parse %s %q of image stream", field, repo)
+			return
+		}
+		ref = ref.DockerClientDefaults()
+		local := ref.AsRepository().Exact()
+		if stringListContains(locals, local) {
+			return
+		}
+		locals = append(locals, local)
+		search[local] = ImagePullthroughSpec{
+			DockerImageReference: &ref,
+			LocalFirst:           true,
+		}
+	}
+
+	if is.preferPublic {
+		addLocal("publicDockerImageRepository", stream.Status.PublicDockerImageRepository)
+	}
+	addLocal("dockerImageRepository", stream.Status.DockerImageRepository)
+
+	for _, repo := range repositoryCandidates {
+		if !stringListContains(locals, repo) {
+			locals = append(locals, repo)
+		}
+	}
+	return locals, search, nil
+}
+
+// IdentifyCandidateRepositoriesByAuth is like IdentifyCandidateRepositories,
+// but it splits the candidates into two lists: the repositories for which the
+// image stream's secrets provide credentials and the repositories that would
+// be accessed anonymously. Both lists keep the order of
+// IdentifyCandidateRepositories.
+func (is *imageStream) IdentifyCandidateRepositoriesByAuth(ctx context.Context, primary bool) ([]string, []string, map[string]ImagePullthroughSpec, rerrors.Error) {
+	repositoryCandidates, search, rErr := is.IdentifyCandidateRepositories(ctx, primary)
+	if rErr != nil {
+		return nil, nil, nil, rErr
+	}
+
+	secrets, rErr := is.GetSecrets()
+	if rErr != nil {
+		return nil, nil, nil, rErr
+	}
+
+	authenticated, anonymous, err := SplitRepositoriesByAuth(repositoryCandidates, secrets, is.defaultKeyring)
+	if err != nil {
+		return nil, nil, nil, rerrors.NewError(
+			ErrImageStreamUnknownErrorCode,
+			fmt.Sprintf("IdentifyCandidateRepositoriesByAuth: unable to create keyring for repository %s", is.Reference()),
+			err,
+		)
+	}
+
+	return authenticated, anonymous, search, nil
+}
+
+// SplitRepositoriesByAuth splits repositories into the ones for which the
+// secrets or defaultKeyring provide credentials and the ones that would be
+// accessed anonymously. defaultKeyring should be the keyring the pull layer
+// falls back to, e.g. the installation credentials; it may be nil. The order of
+// repositories is preserved.
+func SplitRepositoriesByAuth(repositories []string, secrets []corev1.Secret, defaultKeyring credentialprovider.DockerKeyring) ([]string, []string, error) {
+	if defaultKeyring == nil {
+		defaultKeyring = &credentialprovider.BasicDockerKeyring{}
+	}
+
+	keyring, err := credentialprovider.MakeDockerKeyring(secrets, defaultKeyring)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var authenticated, anonymous []string
+	for _, repo := range repositories {
+		if _, found := keyring.Lookup(repo); found {
+			authenticated = append(authenticated, repo)
+		} else {
+			anonymous = append(anonymous, repo)
+		}
+	}
+
+	return authenticated, anonymous, nil
+}
+
+func (is *imageStream) Tags(ctx context.Context) (_ map[string]digest.Digest, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("Tags: failed to get image stream %s", is.Reference()))
+	}
+
+	m := make(map[string]digest.Digest)
+
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+
+		tag := history.Tag
+
+		dgst, err := digest.Parse(history.Items[0].Image)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", history.Items[0].Image, err)
+			if is.onMalformedDigest != nil {
+				is.onMalformedDigest(tag, history.Items[0].Image)
+			}
+			if is.strictDigestParsing {
+				return nil, rerrors.NewError(
+					ErrImageStreamInvalidDigestCode,
+					fmt.Sprintf("Tags: tag %s in image stream %s has invalid digest %q", tag, is.Reference(), history.Items[0].Image),
+					err,
+				)
+			}
+			continue
+		}
+
+		m[tag] = dgst
+	}
+
+	if is.includeEmptyTags {
+		for _, history := range stream.Status.Tags {
+			if len(history.Items) == 0 {
+				m[history.Tag] = ""
+			}
+		}
+		for _, t := range stream.Spec.Tags {
+			if _, ok := m[t.Name]; !ok && util.LatestTaggedImage(stream, t.Name) == nil {
+				m[t.Name] = ""
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// TagHeadStrings returns the tags that point to an image mapped to the
+// images as they are recorded in the image stream. Unlike Tags, it does not
+// parse the digests, it is up to the caller to validate them.
+func (is *imageStream) TagHeadStrings(ctx context.Context) (map[string]string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("TagHeadStrings: failed to get image stream %s", is.Reference()))
+	}
+
+	result := make(map[string]string, len(stream.Status.Tags))
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) > 0 {
+			result[history.Tag] = history.Items[0].Image
+		}
+	}
+	return result, nil
+}
+
+// TagCount returns the number of tags that point to an image. Unlike Tags, it
+// does not parse the digests, so tags with malformed digests are counted too.
+func (is *imageStream) TagCount(ctx context.Context) (int, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return 0, convertImageStreamGetterError(err, fmt.Sprintf("TagCount: failed to get image stream %s", is.Reference()))
+	}
+
+	count := 0
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) > 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// semverTag is a tag whose name can be parsed as a semantic version.
+type semverTag struct {
+	name    string
+	version semver.Version
+}
+
+// sortTagsSemver splits the tags that point to an image into the ones whose
+// names are semantic versions and the others. The semantic version tags are
+// sorted by their versions in ascending order, the other tags are sorted
+// lexically.
+func sortTagsSemver(stream *imageapiv1.ImageStream) ([]semverTag, []string) {
+	var versions []semverTag
+	var others []string
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		version, err := semver.ParseTolerant(history.Tag)
+		if err != nil {
+			others = append(others, history.Tag)
+			continue
+		}
+		versions = append(versions, semverTag{name: history.Tag, version: version})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		if c := versions[i].version.Compare(versions[j].version); c != 0 {
+			return c < 0
+		}
+		return versions[i].name < versions[j].name
+	})
+	sort.Strings(others)
+
+	return versions, others
+}
+
+// TagsSortedSemver returns the tags that point to an image. The tags whose
+// names are semantic versions, e.g. v1.2.3, come first and are sorted by
+// their versions in ascending order. They are followed by the other tags in
+// lexical order.
+func (is *imageStream) TagsSortedSemver(ctx context.Context) ([]string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("TagsSortedSemver: failed to get image stream %s", is.Reference()))
+	}
+
+	versions, others := sortTagsSemver(stream)
+
+	tags := make([]string, 0, len(versions)+len(others))
+	for _, v := range versions {
+		tags = append(tags, v.name)
+	}
+	return append(tags, others...), nil
+}
+
+// ResolveHighestSemverTag returns the latest TagEvent of the tag with the
+// highest semantic version and the name of the tag. Tags whose names are not
+// semantic versions are ignored. If there is no such tag, a not found error is
+// returned.
+func (is *imageStream) ResolveHighestSemverTag(ctx context.Context) (*imageapiv1.TagEvent, string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, "", convertImageStreamGetterError(err, fmt.Sprintf("ResolveHighestSemverTag: failed to get image stream %s", is.Reference()))
+	}
+
+	versions, _ := sortTagsSemver(stream)
+	if len(versions) == 0 {
+		return nil, "", rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("ResolveHighestSemverTag: image stream %s has no semantic version tags", is.Reference()),
+			nil,
+		)
+	}
+
+	tag := versions[len(versions)-1].name
+	return util.LatestTaggedImage(stream, tag), tag, nil
+}
+
+// HistoricalImageCount returns the number of distinct images recorded in the
+// history of all tags, including images that are no longer the latest image
+// of any tag.
+func (is *imageStream) HistoricalImageCount(ctx context.Context) (int, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return 0, convertImageStreamGetterError(err, fmt.Sprintf("HistoricalImageCount: failed to get image stream %s", is.Reference()))
+	}
+
+	images := make(map[string]struct{})
+	for _, history := range stream.Status.Tags {
+		for _, item := range history.Items {
+			images[item.Image] = struct{}{}
+		}
+	}
+	return len(images), nil
+}
+
+// IsEmpty returns true if no tag of the image stream points to an image.
+func (is *imageStream) IsEmpty(ctx context.Context) (bool, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return false, convertImageStreamGetterError(err, fmt.Sprintf("IsEmpty: failed to get image stream %s", is.Reference()))
+	}
+
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// PendingTagCount returns the number of spec tags that do not point to an
+// image yet, e.g. because their import is pending or has failed.
+func (is *imageStream) PendingTagCount(ctx context.Context) (int, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return 0, convertImageStreamGetterError(err, fmt.Sprintf("PendingTagCount: failed to get image stream %s", is.Reference()))
+	}
+
+	count := 0
+	for _, t := range stream.Spec.Tags {
+		if util.LatestTaggedImage(stream, t.Name) == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// TagDrift returns the sorted names of the spec tags that do not point to an
+// image, e.g. because their import is pending or has failed, and the sorted
+// names of the status tags that have no spec tag. The latter include tags
+// whose spec tags have been removed as well as tags that have been pushed
+// into the integrated registry, as pushes do not create spec tags.
+func (is *imageStream) TagDrift(ctx context.Context) (specOnly []string, statusOnly []string, rErr rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, nil, convertImageStreamGetterError(err, fmt.Sprintf("TagDrift: failed to get image stream %s", is.Reference()))
+	}
+
+	spec := make(map[string]bool, len(stream.Spec.Tags))
+	for _, t := range stream.Spec.Tags {
+		spec[t.Name] = true
+	}
+
+	tagged := make(map[string]bool, len(stream.Status.Tags))
+	specOnly, statusOnly = []string{}, []string{}
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) > 0 {
+			tagged[history.Tag] = true
+		}
+		if !spec[history.Tag] {
+			statusOnly = append(statusOnly, history.Tag)
+		}
+	}
+	for _, t := range stream.Spec.Tags {
+		if !tagged[t.Name] {
+			specOnly = append(specOnly, t.Name)
+		}
+	}
+
+	sort.Strings(specOnly)
+	sort.Strings(statusOnly)
+	return specOnly, statusOnly, nil
+}
+
+// Summary returns a summary of the image stream. The dangling tags are the
+// spec tags counted by PendingTagCount.
+func (is *imageStream) Summary(ctx context.Context) (*StreamSummary, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("Summary: failed to get image stream %s", is.Reference()))
+	}
+
+	summary := &StreamSummary{Reference: is.Reference()}
+
+	tagged := make(map[string]bool)
+	images := make(map[string]struct{})
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		tagged[history.Tag] = true
+		images[history.Items[0].Image] = struct{}{}
+		if created := history.Items[0].Created; created.After(summary.LastPushTime.Time) {
+			summary.LastPushTime = created
+		}
+	}
+	summary.TagCount = len(tagged)
+	summary.ImageCount = len(images)
+
+	for _, t := range stream.Spec.Tags {
+		if !tagged[t.Name] {
+			summary.DanglingTagCount++
+		}
+	}
+
+	return summary, nil
+}
+
+// TagConditions returns a copy of the conditions recorded for the tag, e.g.
+// the reason why its import has failed. If the tag is neither in the spec nor
+// in the status of the image stream, a not found error is returned.
+func (is *imageStream) TagConditions(ctx context.Context, tag string) ([]imageapiv1.TagEventCondition, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("TagConditions: failed to get image stream %s", is.Reference()))
+	}
+
+	for _, history := range stream.Status.Tags {
+		if history.Tag != tag {
+			continue
+		}
+		conditions := make([]imageapiv1.TagEventCondition, 0, len(history.Conditions))
+		for _, condition := range history.Conditions {
+			conditions = append(conditions, *condition.DeepCopy())
+		}
+		return conditions, nil
+	}
+
+	for _, t := range stream.Spec.Tags {
+		if t.Name == tag {
+			return []imageapiv1.TagEventCondition{}, nil
+		}
+	}
+
+	return nil, rerrors.NewError(
+		ErrImageStreamImageNotFoundCode,
+		fmt.Sprintf("TagConditions: unable to find tag %s in image stream %s", tag, is.Reference()),
+		nil,
+	)
+}
+
+// TagDockerImageReference returns the DockerImageReference recorded for the
+// latest image of the tag. Depending on the tag's reference policy it may
+// point to the integrated registry or to an external one.
+func (is *imageStream) TagDockerImageReference(ctx context.Context, tag string) (string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return "", convertImageStreamGetterError(err, fmt.Sprintf("TagDockerImageReference: failed to get image stream %s", is.Reference()))
+	}
+
+	tagEvent := util.LatestTaggedImage(stream, tag)
+	if tagEvent == nil {
+		return "", rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("TagDockerImageReference: unable to find tag %s in image stream %s", tag, is.Reference()),
+			nil,
+		)
+	}
+
+	return tagEvent.DockerImageReference, nil
+}
+
+// ResolveCrossStreamTag returns the latest TagEvent of the tag. If the tag's
+// spec references a tag of another image stream in the same namespace (or
+// another tag of this image stream), the reference is followed and the
+// TagEvent of the referenced tag is returned.
+func (is *imageStream) ResolveCrossStreamTag(ctx context.Context, tag string) (*imageapiv1.TagEvent, rerrors.Error) {
+	return is.resolveCrossStreamTag(ctx, tag, make(map[string]bool))
+}
+
+func (is *imageStream) resolveCrossStreamTag(ctx context.Context, tag string, visited map[string]bool) (*imageapiv1.TagEvent, rerrors.Error) {
+	istag := imageapi.JoinImageStreamTag(is.Reference(), tag)
+	if visited[istag] {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("ResolveCrossStreamTag: tag %s references itself", istag),
+			nil,
+		)
+	}
+	visited[istag] = true
+
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("ResolveCrossStreamTag: failed to get image stream %s", is.Reference()))
+	}
+
+	for _, t := range stream.Spec.Tags {
+		if t.Name != tag {
+			continue
+		}
+		name, targetTag, ok := specTagReference(stream, t)
+		if !ok {
+			break
+		}
+		target := is
+		if name != is.name {
+			if target, err = is.sibling(name); err != nil {
+				return nil, err
+			}
+		}
+		return target.resolveCrossStreamTag(ctx, targetTag, visited)
+	}
+
+	tagEvent := util.LatestTaggedImage(stream, tag)
+	if tagEvent == nil {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("ResolveCrossStreamTag: unable to find tag %s", istag),
+			nil,
+		)
+	}
+
+	return tagEvent, nil
+}
+
+// ValidateTagSource checks that the From reference of the spec tag can be
+// resolved. The reference has to be parseable, otherwise an error with the
+// code ErrImageStreamTagSourceInvalidCode is returned. If it references an
+// image stream tag, the tag has to exist, otherwise an error with the code
+// ErrImageStreamTagSourceNotFoundCode is returned.
+func (is *imageStream) ValidateTagSource(ctx context.Context, tag string) rerrors.Error {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return convertImageStreamGetterError(rErr, fmt.Sprintf("ValidateTagSource: failed to get image stream %s", is.Reference()))
+	}
+
+	var from *corev1.ObjectReference
+	found := false
+	for _, t := range stream.Spec.Tags {
+		if t.Name == tag {
+			from = t.From
+			found = true
+			break
+		}
+	}
+	if !found {
+		return rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("ValidateTagSource: unable to find spec tag %s in image stream %s", tag, is.Reference()),
+			nil,
+		)
+	}
+	if from == nil {
+		return rerrors.NewError(
+			ErrImageStreamTagSourceInvalidCode,
+			fmt.Sprintf("ValidateTagSource: spec tag %s in image stream %s has no source", tag, is.Reference()),
+			nil,
+		)
+	}
+
+	switch from.Kind {
+	case "DockerImage":
+		if _, err := reference.Parse(from.Name); err != nil {
+			return rerrors.NewError(
+				ErrImageStreamTagSourceInvalidCode,
+				fmt.Sprintf("ValidateTagSource: spec tag %s in image stream %s has invalid source %q", tag, is.Reference(), from.Name),
+				err,
+			)
+		}
+		return nil
+	case "ImageStreamImage":
+		if _, _, err := imageapi.ParseImageStreamImageName(from.Name); err != nil {
+			return rerrors.NewError(
+				ErrImageStreamTagSourceInvalidCode,
+				fmt.Sprintf("ValidateTagSource: spec tag %s in image stream %s has invalid source %q", tag, is.Reference(), from.Name),
+				err,
+			)
+		}
+		return nil
+	case "ImageStreamTag":
+	default:
+		return rerrors.NewError(
+			ErrImageStreamTagSourceInvalidCode,
+			fmt.Sprintf("ValidateTagSource: spec tag %s in image stream %s has source of unsupported kind %q", tag, is.Reference(), from.Kind),
+			nil,
+		)
+	}
+
+	namespace := from.Namespace
+	if len(namespace) == 0 {
+		namespace = is.namespace
+	}
+	name, targetTag, ok := imageapi.SplitImageStreamTag(from.Name)
+	if !ok {
+		// a reference to another tag of the same image stream
+		name, targetTag = is.name, from.Name
+	}
+	if len(name) == 0 || len(targetTag) == 0 {
+		return rerrors.NewError(
+			ErrImageStreamTagSourceInvalidCode,
+			fmt.Sprintf("ValidateTagSource: spec tag %s in image stream %s has invalid source %q", tag, is.Reference(), from.Name),
+			nil,
+		)
+	}
+
+	target := stream
+	if namespace != is.namespace || name != is.name {
+		getter, rErr := is.newImageStreamGetter(namespace, name)
+		if rErr != nil {
+			return rErr
+		}
+		target, rErr = getter.Get(ctx)
+		if rErr != nil && rErr.Code() == ErrImageStreamGetterNotFoundCode {
+			return rerrors.NewError(
+				ErrImageStreamTagSourceNotFoundCode,
+				fmt.Sprintf("ValidateTagSource: image stream %s/%s referenced by spec tag %s in image stream %s does not exist", namespace, name, tag, is.Reference()),
+				rErr,
+			)
+		}
+		if rErr != nil {
+			return convertImageStreamGetterError(rErr, fmt.Sprintf("ValidateTagSource: failed to get image stream %s/%s", namespace, name))
+		}
+	}
+
+	if util.LatestTaggedImage(target, targetTag) == nil {
+		return rerrors.NewError(
+			ErrImageStreamTagSourceNotFoundCode,
+			fmt.Sprintf("ValidateTagSource: image stream tag %s/%s referenced by spec tag %s in image stream %s does not exist", namespace, imageapi.JoinImageStreamTag(name, targetTag), tag, is.Reference()),
+			nil,
+		)
+	}
+
+	return nil
+}
+
+// CanonicalTag follows the spec tags that reference other tags of the same
+// image stream and returns the tag at the end of the chain. The returned tag
+// has to point to an image. If the chain contains a cycle or ends at a tag
+// without images, an error with the code ErrImageStreamImageNotFoundCode is
+// returned.
+func (is *imageStream) CanonicalTag(ctx context.Context, tag string) (string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return "", convertImageStreamGetterError(err, fmt.Sprintf("CanonicalTag: failed to get image stream %s", is.Reference()))
+	}
+
+	visited := make(map[string]bool)
+	for {
+		if visited[tag] {
+			return "", rerrors.NewError(
+				ErrImageStreamImageNotFoundCode,
+				fmt.Sprintf("CanonicalTag: tag %s references itself", imageapi.JoinImageStreamTag(is.Reference(), tag)),
+				nil,
+			)
+		}
+		visited[tag] = true
+
+		next := ""
+		for _, t := range stream.Spec.Tags {
+			if t.Name != tag {
+				continue
+			}
+			if name, targetTag, ok := specTagReference(stream, t); ok && name == stream.Name {
+				next = targetTag
+			}
+			break
+		}
+		if len(next) == 0 {
+			break
+		}
+		tag = next
+	}
+
+	if util.LatestTaggedImage(stream, tag) == nil {
+		return "", rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("CanonicalTag: unable to find tag %s", imageapi.JoinImageStreamTag(is.Reference(), tag)),
+			nil,
+		)
+	}
+
+	return tag, nil
+}
+
+// DetectTagCycles returns the cycles formed by spec tags that reference other
+// tags of the same image stream. Each cycle lists the tags in the order in
+// which they reference each other, starting with the lexically smallest tag.
+// The cycles are sorted by their first tags.
+func (is *imageStream) DetectTagCycles(ctx context.Context) ([][]string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("DetectTagCycles: failed to get image stream %s", is.Reference()))
+	}
+
+	// every tag references at most one other tag
+	next := make(map[string]string)
+	for _, t := range stream.Spec.Tags {
+		if name, targetTag, ok := specTagReference(stream, t); ok && name == stream.Name {
+			next[t.Name] = targetTag
+		}
+	}
+
+	tags := make([]string, 0, len(next))
+	for tag := range next {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var cycles [][]string
+	done := make(map[string]bool)
+	for _, start := range tags {
+		// walk from start until the path reaches a tag that has been seen
+		// before, either on this path or on an earlier one
+		onPath := make(map[string]bool)
+		tag := start
+		cyclic := false
+		for !done[tag] {
+			if onPath[tag] {
+				cyclic = true
+				break
+			}
+			onPath[tag] = true
+			target, ok := next[tag]
+			if !ok {
+				break
+			}
+			tag = target
+		}
+		if cyclic {
+			// the path ran into itself, tag is part of a new cycle
+			cycle := []string{tag}
+			for t := next[tag]; t != tag; t = next[t] {
+				cycle = append(cycle, t)
+			}
+			smallest := 0
+			for i := range cycle {
+				if cycle[i] < cycle[smallest] {
+					smallest = i
+				}
+			}
+			rotated := make([]string, 0, len(cycle))
+			rotated = append(rotated, cycle[smallest:]...)
+			cycles = append(cycles, append(rotated, cycle[:smallest]...))
+		}
+		for t := range onPath {
+			done[t] = true
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+	return cycles, nil
+}
+
+// specTagReference returns the image stream name and the tag the spec tag t
+// of stream references. It returns false if t does not reference an image
+// stream tag in the same namespace.
+func specTagReference(stream *imageapiv1.ImageStream, t imageapiv1.TagReference) (string, string, bool) {
+	if t.From == nil || t.From.Kind != "ImageStreamTag" {
+		return "", "", false
+	}
+	if len(t.From.Namespace) != 0 && t.From.Namespace != stream.Namespace {
+		return "", "", false
+	}
+	name, tag, ok := imageapi.SplitImageStreamTag(t.From.Name)
+	if !ok {
+		// a reference to another tag of the same image stream
+		return stream.Name, t.From.Name, true
+	}
+	if len(name) == 0 {
+		name = stream.Name
+	}
+	return name, tag, true
+}
+
+// sibling returns an ImageStream for another image stream in the same
+// namespace. It shares the configuration and the image getter with is.
+func (is *imageStream) sibling(name string) (*imageStream, rerrors.Error) {
+	getter, err := is.newImageStreamGetter(is.namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	sibling := *is
+	sibling.name = name
+	sibling.imageStreamGetter = getter
+	sibling.anonymousPull = &anonymousPullCache{}
+	return &sibling, nil
+}
+
+// newImageStreamGetter returns a getter for the image stream namespace/name
+// that is configured like the getter of is. An error with the code
+// ErrImageStreamNotFoundCode is returned if is has neither a getter factory
+// nor a master API client to create the getter from.
+func (is *imageStream) newImageStreamGetter(namespace, name string) (ImageStreamGetter, rerrors.Error) {
+	var getter ImageStreamGetter
+	switch {
+	case is.getterFactory != nil:
+		getter = is.getterFactory(namespace, name)
+	case is.registryOSClient != nil:
+		getter = &cachedImageStreamGetter{
+			namespace:    namespace,
+			name:         name,
+			isNamespacer: is.registryOSClient,
+		}
+	default:
+		return nil, rerrors.NewError(
+			ErrImageStreamNotFoundCode,
+			fmt.Sprintf("newImageStreamGetter: no client to get image stream %s/%s", namespace, name),
+			nil,
+		)
+	}
+	if is.cacheObserver != nil {
+		getter = &observedImageStreamGetter{ImageStreamGetter: getter, observer: is.cacheObserver}
+	}
+	if is.tracer != nil {
+		getter = &tracingImageStreamGetter{ImageStreamGetter: getter, tracer: is.tracer}
+	}
+	return getter, nil
+}
+
+// MostTaggedImages returns the topN images referenced by the largest number
+// of tags, ordered by the number of tags in descending order. A tag
+// references every image in its history. If topN is not positive, all images
+// are returned.
+func (is *imageStream) MostTaggedImages(ctx context.Context, topN int) ([]ImageTagCount, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("MostTaggedImages: failed to get image stream %s", is.Reference()))
+	}
+
+	counts := make(map[digest.Digest]int)
+	for _, history := range stream.Status.Tags {
+		seen := make(map[digest.Digest]bool)
+		for _, item := range history.Items {
+			dgst, err := digest.Parse(item.Image)
+			if err != nil {
+				dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", item.Image, err)
+				continue
+			}
+			if !seen[dgst] {
+				seen[dgst] = true
+				counts[dgst]++
+			}
+		}
+	}
+
+	result := make([]ImageTagCount, 0, len(counts))
+	for dgst, count := range counts {
+		result = append(result, ImageTagCount{Digest: dgst, TagCount: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TagCount != result[j].TagCount {
+			return result[i].TagCount > result[j].TagCount
+		}
+		return result[i].Digest < result[j].Digest
+	})
+
+	if topN > 0 && len(result) > topN {
+		result = result[:topN]
+	}
+	return result, nil
+}
+
+// IsTagHead returns true if the image with the given digest is the latest
+// image of any tag of the image stream, and the sorted names of these tags.
+func (is *imageStream) IsTagHead(ctx context.Context, dgst digest.Digest) (bool, []string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return false, nil, convertImageStreamGetterError(err, fmt.Sprintf("IsTagHead: failed to get image stream %s", is.Reference()))
+	}
+
+	var tags []string
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) > 0 && history.Items[0].Image == dgst.String() {
+			tags = append(tags, history.Tag)
+		}
+	}
+	sort.Strings(tags)
+
+	return len(tags) > 0, tags, nil
+}
+
+// CommonAncestor returns the most recent image that is present in the
+// histories of both tags and true, or false if the histories have no image in
+// common. The images are compared by the newest time they have been tagged
+// with either tag. If any of the tags does not exist, a not found error is
+// returned.
+func (is *imageStream) CommonAncestor(ctx context.Context, tagA, tagB string) (digest.Digest, bool, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return "", false, convertImageStreamGetterError(err, fmt.Sprintf("CommonAncestor: failed to get image stream %s", is.Reference()))
+	}
+
+	findHistory := func(tag string) *imageapiv1.NamedTagEventList {
+		for i := range stream.Status.Tags {
+			if stream.Status.Tags[i].Tag == tag {
+				return &stream.Status.Tags[i]
+			}
+		}
+		return nil
+	}
+	historyA, historyB := findHistory(tagA), findHistory(tagB)
+	for _, missing := range []struct {
+		tag     string
+		history *imageapiv1.NamedTagEventList
+	}{{tagA, historyA}, {tagB, historyB}} {
+		if missing.history == nil {
+			return "", false, rerrors.NewError(
+				ErrImageStreamImageNotFoundCode,
+				fmt.Sprintf("CommonAncestor: unable to find tag %s in image stream %s", missing.tag, is.Reference()),
+				nil,
+			)
+		}
+	}
+
+	// the newest time each image of tagA has been tagged
+	createdA := make(map[string]metav1.Time)
+	for _, item := range historyA.Items {
+		if created, ok := createdA[item.Image]; !ok || item.Created.After(created.Time) {
+			createdA[item.Image] = item.Created
+		}
+	}
+
+	var newest string
+	var newestCreated metav1.Time
+	for _, item := range historyB.Items {
+		created, ok := createdA[item.Image]
+		if !ok {
+			continue
+		}
+		if item.Created.After(created.Time) {
+			created = item.Created
+		}
+		if len(newest) == 0 || created.After(newestCreated.Time) {
+			newest = item.Image
+			newestCreated = created
+		}
+	}
+	if len(newest) == 0 {
+		return "", false, nil
+	}
+
+	dgst, parseErr := digest.Parse(newest)
+	if parseErr != nil {
+		return "", false, rerrors.NewError(
+			ErrImageStreamInvalidDigestCode,
+			fmt.Sprintf("CommonAncestor: bad digest %s in image stream %s", newest, is.Reference()),
+			parseErr,
+		)
+	}
+	return dgst, true, nil
+}
+
+// TagsContainingImage returns the tags whose history contains the image with
+// the given digest, mapped to the positions of the image in the history. The
+// position 0 is the latest image of the tag.
+func (is *imageStream) TagsContainingImage(ctx context.Context, dgst digest.Digest) (map[string][]int, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("TagsContainingImage: failed to get image stream %s", is.Reference()))
+	}
+
+	result := make(map[string][]int)
+	for _, history := range stream.Status.Tags {
+		for i, item := range history.Items {
+			if item.Image == dgst.String() {
+				result[history.Tag] = append(result[history.Tag], i)
+			}
+		}
+	}
+	return result, nil
+}
+
+// ExternalTags returns the tags whose latest images are referenced from a
+// registry other than the integrated registry, mapped to these references.
+func (is *imageStream) ExternalTags(ctx context.Context) (map[string]string, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("ExternalTags: failed to get image stream %s", is.Reference()))
+	}
+
+	localRegistry, _ := is.localRegistry(ctx)
+
+	result := make(map[string]string)
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		dockerImageReference := history.Items[0].DockerImageReference
+		ref, err := reference.Parse(dockerImageReference)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("ExternalTags: bad reference %s of tag %s: %v", dockerImageReference, history.Tag, err)
+			continue
+		}
+		if stringListContains(localRegistry, ref.Registry) {
+			continue
+		}
+		result[history.Tag] = dockerImageReference
+	}
+
+	return result, nil
+}
+
+// UpstreamRegistryCount returns the number of distinct registries other than
+// the integrated registry that are referenced by the tag histories of the
+// image stream.
+func (is *imageStream) UpstreamRegistryCount(ctx context.Context) (int, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return 0, convertImageStreamGetterError(rErr, fmt.Sprintf("UpstreamRegistryCount: failed to get image stream %s", is.Reference()))
+	}
+
+	localRegistry, _ := is.localRegistry(ctx)
+
+	registries := make(map[string]bool)
+	for _, history := range stream.Status.Tags {
+		for _, item := range history.Items {
+			ref, err := reference.Parse(item.DockerImageReference)
+			if err != nil {
+				continue
+			}
+			if stringListContains(localRegistry, ref.Registry) {
+				continue
+			}
+			registries[ref.DockerClientDefaults().Registry] = true
+		}
+	}
+
+	return len(registries), nil
+}
+
+// StaleTags returns the tags whose latest images do not exist anymore
+// according to imageExists, e.g. because they have been pruned.
+func (is *imageStream) StaleTags(ctx context.Context, imageExists func(digest.Digest) bool) ([]string, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("StaleTags: failed to get image stream %s", is.Reference()))
+	}
+
+	var tags []string
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+
+		dgst, err := digest.Parse(history.Items[0].Image)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", history.Items[0].Image, err)
+			continue
+		}
+
+		if !imageExists(dgst) {
+			tags = append(tags, history.Tag)
+		}
+	}
+
+	return tags, nil
+}
+
+// UnreferencedImages returns the images that are present in the tag
+// histories of the image stream, but are not the latest image of any tag.
+// From the image stream's perspective, these images can be pruned.
+func (is *imageStream) UnreferencedImages(ctx context.Context) ([]digest.Digest, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("UnreferencedImages: failed to get image stream %s", is.Reference()))
+	}
+
+	heads := make(map[string]bool)
+	for _, history := range stream.Status.Tags {
+		if len(history.Items) > 0 {
+			heads[history.Items[0].Image] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var images []digest.Digest
+	for _, history := range stream.Status.Tags {
+		for _, item := range history.Items {
+			if heads[item.Image] || seen[item.Image] {
+				continue
+			}
+			seen[item.Image] = true
+
+			dgst, err := digest.Parse(item.Image)
+			if err != nil {
+				dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", item.Image, err)
+				continue
+			}
+			images = append(images, dgst)
+		}
+	}
+
+	return images, nil
+}
+
+// ImagesInTimeRange returns the distinct images that have been tagged between
+// from and to, inclusive, according to the tag histories of the image stream.
+func (is *imageStream) ImagesInTimeRange(ctx context.Context, from, to time.Time) ([]digest.Digest, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("ImagesInTimeRange: failed to get image stream %s", is.Reference()))
+	}
+
+	seen := make(map[string]bool)
+	var images []digest.Digest
+	for _, history := range stream.Status.Tags {
+		for _, item := range history.Items {
+			if item.Created.Time.Before(from) || item.Created.Time.After(to) || seen[item.Image] {
+				continue
+			}
+			seen[item.Image] = true
+
+			dgst, err := digest.Parse(item.Image)
+			if err != nil {
+				dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", item.Image, err)
+				continue
+			}
+			images = append(images, dgst)
+		}
+	}
+
+	return images, nil
+}
+
+// checkProtectedTag returns an error if tag is protected and already points to
+// an image other than dgst.
+func (is *imageStream) checkProtectedTag(ctx context.Context, tag string, dgst string) rerrors.Error {
+	if !is.protectedTags[tag] || protectedTagOverride(ctx) {
+		return nil
+	}
+
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		if err.Code() == ErrImageStreamGetterNotFoundCode {
+			return nil
+		}
+		return convertImageStreamGetterError(err, fmt.Sprintf("CreateImageStreamMapping: failed to get image stream %s", is.Reference()))
+	}
+
+	for _, history := range stream.Status.Tags {
+		if history.Tag != tag || len(history.Items) == 0 {
+			continue
+		}
+		if history.Items[0].Image == dgst {
+			return nil
+		}
+		return rerrors.NewError(
+			ErrImageStreamForbiddenCode,
+			fmt.Sprintf("CreateImageStreamMapping: tag %s of %s is protected and already points to image %s", tag, is.Reference(), history.Items[0].Image),
+			nil,
+		)
+	}
+
+	return nil
+}
+
+func (is *imageStream) CreateImageStreamMapping(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) (resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
+	if is.tracer == nil {
+		return is.createImageStreamMapping(ctx, userClient, tag, image)
+	}
+
+	ctx, span := is.tracer.Start(ctx, "imagestream.CreateImageStreamMapping")
+	err := is.createImageStreamMapping(ctx, userClient, tag, image)
+	endSpan(span, err)
+	return err
+}
+
+// EnsureTag makes sure the tag exists. If the tag has no image yet, an image
+// stream mapping that points the tag to image is created, otherwise the tag
+// is left as it is. It returns true if the mapping has been created.
+func (is *imageStream) EnsureTag(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) (bool, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil && err.Code() != ErrImageStreamGetterNotFoundCode {
+		return false, convertImageStreamGetterError(err, fmt.Sprintf("EnsureTag: failed to get image stream %s", is.Reference()))
+	}
+	if err == nil && util.LatestTaggedImage(stream, tag) != nil {
+		return false, nil
+	}
+
+	if err := is.CreateImageStreamMapping(ctx, userClient, tag, image); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (is *imageStream) createImageStreamMapping(ctx context.Context, userClient client.Interface, tag string, image *imageapiv1.Image) rerrors.Error {
+	if rErr := is.checkProtectedTag(ctx, tag, image.Name); rErr != nil {
+		return rErr
+	}
+
+	ism := imageapiv1.ImageStreamMapping{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: is.namespace,
+			Name:      is.name,
+		},
+		Image: *image,
+		Tag:   tag,
+	}
+
+	err := is.postImageStreamMapping(ctx, &ism)
+
+	if err == nil {
+		is.recordMapping(ctx, tag, image.Name, false)
+		return nil
+	}
+
+	if kerrors.IsConflict(err) {
+		return rerrors.NewError(
+			ErrImageStreamConflictCode,
+			fmt.Sprintf("CreateImageStreamMapping: conflict during creation of %s ImageStreamMapping", is.Reference()),
+			err,
+		)
 	}
 
 	if quotautil.IsErrorQuotaExceeded(err) {
@@ -463,12 +3035,24 @@ func (is *imageStream) CreateImageStreamMapping(ctx context.Context, userClient
 
 	stream := &imageapiv1.ImageStream{}
 	stream.Name = is.name
+	if is.annotateAutoProvisioned {
+		stream.Annotations = map[string]string{
+			AutoProvisionedAnnotation: "true",
+		}
+	}
 
-	_, err = userClient.ImageStreams(is.namespace).Create(ctx, stream, metav1.CreateOptions{})
+	created, err := userClient.ImageStreams(is.namespace).Create(ctx, stream, metav1.CreateOptions{})
 
 	switch {
 	case kerrors.IsAlreadyExists(err), kerrors.IsConflict(err):
-		// It is ok.
+		// It is ok, the image stream has been created concurrently. Fetch
+		// it so that the cache holds the actual object.
+		existing, getErr := is.registryOSClient.ImageStreams(is.namespace).Get(ctx, is.name, metav1.GetOptions{})
+		if getErr != nil {
+			dcontext.GetLogger(ctx).Warnf("CreateImageStreamMapping: unable to get concurrently created ImageStream %s: %v", is.Reference(), getErr)
+		} else {
+			stream = existing
+		}
 	case kerrors.IsForbidden(err), kerrors.IsUnauthorized(err), quotautil.IsErrorQuotaExceeded(err):
 		return rerrors.NewError(
 			ErrImageStreamForbiddenCode,
@@ -481,18 +3065,29 @@ func (is *imageStream) CreateImageStreamMapping(ctx context.Context, userClient
 			fmt.Sprintf("CreateImageStreamMapping: error auto provisioning ImageStream %s", is.Reference()),
 			err,
 		)
+	case created != nil:
+		stream = created
 	}
 
 	dcontext.GetLogger(ctx).Debugf("cache image stream %s/%s", stream.Namespace, stream.Name)
-	is.imageStreamGetter.cacheImageStream(stream)
+	is.imageStreamGetter.CacheImageStream(stream)
 
 	// try to create the ISM again
-	_, err = is.registryOSClient.ImageStreamMappings(is.namespace).Create(ctx, &ism, metav1.CreateOptions{})
+	err = is.postImageStreamMapping(ctx, &ism)
 
 	if err == nil {
+		is.recordMapping(ctx, tag, image.Name, true)
 		return nil
 	}
 
+	if kerrors.IsConflict(err) {
+		return rerrors.NewError(
+			ErrImageStreamConflictCode,
+			fmt.Sprintf("CreateImageStreamMapping: conflict during creation of %s ImageStreamMapping second time", is.Reference()),
+			err,
+		)
+	}
+
 	if quotautil.IsErrorQuotaExceeded(err) {
 		return rerrors.NewError(
 			ErrImageStreamForbiddenCode,
@@ -502,18 +3097,78 @@ func (is *imageStream) CreateImageStreamMapping(ctx context.Context, userClient
 	}
 
 	return rerrors.NewError(
-		ErrImageStreamUnknownErrorCode,
+		ErrImageStreamProvisionRetryFailedCode,
 		fmt.Sprintf("CreateImageStreamMapping: error creating %s ImageStreamMapping second time", is.Reference()),
 		err,
 	)
 }
 
+// maxImageStreamMappingConflictRetries is the number of times the creation
+// of an ImageStreamMapping is retried after a conflict.
+const maxImageStreamMappingConflictRetries = 3
+
+// imageStreamMappingConflictBackoff is the backoff between the retries after a
+// conflict. It matches retry.DefaultBackoff of client-go, whose retry package
+// is not vendored.
+var imageStreamMappingConflictBackoff = wait.Backoff{
+	Steps:    maxImageStreamMappingConflictRetries + 1,
+	Duration: 10 * time.Millisecond,
+	Factor:   5.0,
+	Jitter:   0.1,
+}
+
+// postImageStreamMapping creates the ImageStreamMapping. If the creation
+// fails because the image stream has been updated concurrently, the image
+// stream is fetched again and the creation is retried with a backoff. If the
+// image stream cannot be fetched, the conflict is returned without further
+// retries.
+func (is *imageStream) postImageStreamMapping(ctx context.Context, ism *imageapiv1.ImageStreamMapping) error {
+	var err error
+	attempt := 0
+	waitErr := wait.ExponentialBackoffWithContext(ctx, imageStreamMappingConflictBackoff, func() (bool, error) {
+		if attempt > 0 {
+			stream, getErr := is.registryOSClient.ImageStreams(is.namespace).Get(ctx, is.name, metav1.GetOptions{})
+			if getErr != nil {
+				dcontext.GetLogger(ctx).Warnf("postImageStreamMapping: unable to refetch ImageStream %s, giving up: %v", is.Reference(), getErr)
+				return true, nil
+			}
+			is.imageStreamGetter.CacheImageStream(stream)
+		}
+		attempt++
+
+		_, err = is.registryOSClient.ImageStreamMappings(is.namespace).Create(ctx, ism, metav1.CreateOptions{})
+		if kerrors.IsConflict(err) {
+			dcontext.GetLogger(ctx).Debugf("postImageStreamMapping: conflict during creation of %s ImageStreamMapping, retrying: %v", is.Reference(), err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if waitErr != nil && waitErr != wait.ErrWaitTimeout {
+		return waitErr
+	}
+	return err
+}
+
 // GetLimitRangeList returns list of limit ranges for repo.
-func (is *imageStream) GetLimitRangeList(ctx context.Context, cache ProjectObjectListStore) (*corev1.LimitRangeList, rerrors.Error) {
+//
+// If maxAge is positive and cache is a TimestampedProjectObjectListStore,
+// cached lists older than maxAge are fetched again. Otherwise cached lists are
+// used as long as the cache holds them. The age is measured with the clock of
+// the ImageStream.
+func (is *imageStream) GetLimitRangeList(ctx context.Context, cache ProjectObjectListStore, maxAge time.Duration) (_ *corev1.LimitRangeList, resultErr rerrors.Error) {
+	defer func() { resultErr = is.redact(ctx, resultErr) }()
+
 	if cache != nil {
-		obj, exists, _ := cache.Get(is.namespace)
-		if exists {
-			return obj.(*corev1.LimitRangeList), nil
+		if tsCache, ok := cache.(TimestampedProjectObjectListStore); ok && maxAge > 0 {
+			obj, added, exists, _ := tsCache.GetWithTimestamp(is.namespace)
+			if exists && is.now().Sub(added) <= maxAge {
+				return obj.(*corev1.LimitRangeList), nil
+			}
+		} else {
+			obj, exists, _ := cache.Get(is.namespace)
+			if exists {
+				return obj.(*corev1.LimitRangeList), nil
+			}
 		}
 	}
 
@@ -538,6 +3193,31 @@ func (is *imageStream) GetLimitRangeList(ctx context.Context, cache ProjectObjec
 	return lrs, nil
 }
 
+// redact replaces the message of err with a generic one if error redaction
+// is enabled. It is used by the methods whose errors may be sent to clients.
+// The original error, including its cause, is logged as a warning, so that
+// the details are available at the default log level.
+func (is *imageStream) redact(ctx context.Context, err rerrors.Error) rerrors.Error {
+	if err == nil || !is.redactErrors {
+		return err
+	}
+
+	dcontext.GetLogger(ctx).Warnf("redacting error: %v", err)
+
+	msg := "internal error"
+	switch err.Code() {
+	case ErrImageStreamNotFoundCode:
+		msg = "repository not found"
+	case ErrImageStreamImageNotFoundCode:
+		msg = "image not found"
+	case ErrImageStreamForbiddenCode:
+		msg = "access denied"
+	case ErrImageStreamConflictCode:
+		msg = "conflict"
+	}
+	return rerrors.NewError(err.Code(), msg, nil)
+}
+
 func convertImageStreamGetterError(err rerrors.Error, msg string) rerrors.Error {
 	code := ErrImageStreamUnknownErrorCode
 
@@ -546,6 +3226,8 @@ func convertImageStreamGetterError(err rerrors.Error, msg string) rerrors.Error
 		code = ErrImageStreamNotFoundCode
 	case ErrImageStreamGetterForbiddenCode:
 		code = ErrImageStreamForbiddenCode
+	case ErrImageStreamGetterLayersUnsupportedCode:
+		code = ErrImageStreamLayersUnsupportedCode
 	}
 
 	return rerrors.NewError(code, msg, err)
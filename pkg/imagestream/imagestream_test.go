@@ -0,0 +1,1826 @@
+package imagestream
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	authorizationapi "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	"github.com/openshift/image-registry/pkg/dockerregistry/server/client"
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+	"github.com/openshift/image-registry/pkg/kubernetes-common/credentialprovider"
+	"github.com/openshift/image-registry/pkg/testutil"
+	"github.com/openshift/library-go/pkg/image/reference"
+)
+
+type fakeImageGetter map[digest.Digest]*imageapiv1.Image
+
+func (ig fakeImageGetter) Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	image, ok := ig[dgst]
+	if !ok {
+		return nil, rerrors.NewError(ErrImageGetterNotFoundCode, dgst.String(), kerrors.NewNotFound(imageapiv1.Resource("images"), dgst.String()))
+	}
+	return image, nil
+}
+
+func newTestImageStream(stream *imageapiv1.ImageStream) *imageStream {
+	return &imageStream{
+		namespace: stream.Namespace,
+		name:      stream.Name,
+		imageStreamGetter: &cachedImageStreamGetter{
+			namespace:               stream.Namespace,
+			name:                    stream.Name,
+			cachedImageStream:       stream,
+			cachedImageStreamLayers: &imageapiv1.ImageStreamLayers{},
+		},
+		maxManifestListDepth: defaultMaxManifestListDepth,
+		anonymousPull:        &anonymousPullCache{},
+	}
+}
+
+func TestIsLocalOnly(t *testing.T) {
+	local := imageapiv1.TagReferencePolicy{Type: imageapiv1.LocalTagReferencePolicy}
+	source := imageapiv1.TagReferencePolicy{Type: imageapiv1.SourceTagReferencePolicy}
+
+	for _, tc := range []struct {
+		name     string
+		stream   *imageapiv1.ImageStream
+		expected bool
+	}{
+		{
+			name:   "empty image stream",
+			stream: &imageapiv1.ImageStream{},
+		},
+		{
+			name: "all tags local",
+			stream: &imageapiv1.ImageStream{
+				Spec: imageapiv1.ImageStreamSpec{
+					Tags: []imageapiv1.TagReference{
+						{Name: "latest", ReferencePolicy: local},
+						{Name: "stable", ReferencePolicy: local},
+					},
+				},
+				Status: imageapiv1.ImageStreamStatus{
+					Tags: []imageapiv1.NamedTagEventList{{Tag: "latest"}},
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "one source tag",
+			stream: &imageapiv1.ImageStream{
+				Spec: imageapiv1.ImageStreamSpec{
+					Tags: []imageapiv1.TagReference{
+						{Name: "latest", ReferencePolicy: local},
+						{Name: "stable", ReferencePolicy: source},
+					},
+				},
+			},
+		},
+		{
+			name: "pushed tag without spec",
+			stream: &imageapiv1.ImageStream{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+				Spec: imageapiv1.ImageStreamSpec{
+					Tags: []imageapiv1.TagReference{
+						{Name: "latest", ReferencePolicy: local},
+					},
+				},
+				Status: imageapiv1.ImageStreamStatus{
+					Tags: []imageapiv1.NamedTagEventList{{Tag: "pushed"}},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.WithTestLogger(context.Background(), t)
+			is := newTestImageStream(tc.stream)
+
+			localOnly, err := is.IsLocalOnly(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if localOnly != tc.expected {
+				t.Errorf("got %v, want %v", localOnly, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNewWithGetters(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	dgst := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+	images := fakeImageGetter{
+		dgst: {
+			ObjectMeta:           metav1.ObjectMeta{Name: dgst.String()},
+			DockerImageReference: "registry.example.org/ns/is@" + dgst.String(),
+		},
+	}
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "latest",
+					Items: []imageapiv1.TagEvent{
+						{Image: dgst.String(), DockerImageReference: "docker.io/library/busybox@" + dgst.String()},
+					},
+				},
+			},
+		},
+	}
+	streamGetter := &cachedImageStreamGetter{cachedImageStream: stream}
+
+	is := NewWithGetters(ctx, "ns", "is", nil, images, streamGetter)
+
+	image, err := is.GetImageOfImageStream(ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "docker.io/library/busybox@" + dgst.String(); image.DockerImageReference != expected {
+		t.Errorf("got reference %q, want %q", image.DockerImageReference, expected)
+	}
+	if images[dgst].DockerImageReference == image.DockerImageReference {
+		t.Errorf("the image returned by the getter has been mutated")
+	}
+}
+
+func TestGetImageOfImageStreamTruncatedHistory(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	current := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+	pruned := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+	unknown := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000003")
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "latest",
+					Items: []imageapiv1.TagEvent{
+						{Image: current.String(), DockerImageReference: "docker.io/library/busybox@" + current.String()},
+					},
+				},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		current: {ObjectMeta: metav1.ObjectMeta{Name: current.String()}},
+		pruned: {
+			ObjectMeta:           metav1.ObjectMeta{Name: pruned.String()},
+			DockerImageReference: "docker.io/library/busybox@" + pruned.String(),
+		},
+		unknown: {ObjectMeta: metav1.ObjectMeta{Name: unknown.String()}},
+	}
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			current.String(): {},
+			pruned.String():  {},
+		},
+	}
+
+	image, err := is.GetImageOfImageStream(ctx, pruned)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "docker.io/library/busybox@" + pruned.String(); image.DockerImageReference != expected {
+		t.Errorf("got reference %q, want %q", image.DockerImageReference, expected)
+	}
+
+	if _, err := is.GetImageOfImageStream(ctx, unknown); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+
+	prunedImage := is.imageClient.(fakeImageGetter)[pruned]
+	rewritten, err := is.RewriteReference(ctx, prunedImage, pruned)
+	if err != nil {
+		t.Fatalf("RewriteReference: unexpected error: %v", err)
+	}
+	if rewritten.DockerImageReference != image.DockerImageReference {
+		t.Errorf("RewriteReference: got reference %q, want %q", rewritten.DockerImageReference, image.DockerImageReference)
+	}
+	if rewritten == prunedImage {
+		t.Errorf("RewriteReference: got the original image, want a copy")
+	}
+}
+
+func TestImageTransform(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	dgst := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+	images := fakeImageGetter{
+		dgst: {
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   dgst.String(),
+				Labels: map[string]string{"internal": "true"},
+			},
+			DockerImageReference: "registry.example.org/ns/is@" + dgst.String(),
+		},
+	}
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "latest",
+					Items: []imageapiv1.TagEvent{
+						{Image: dgst.String(), DockerImageReference: "docker.io/library/busybox@" + dgst.String()},
+					},
+				},
+			},
+		},
+	}
+	streamGetter := &cachedImageStreamGetter{cachedImageStream: stream}
+
+	var transformedReference string
+	is := NewWithGetters(ctx, "ns", "is", nil, images, streamGetter, WithImageTransform(func(image *imageapiv1.Image) *imageapiv1.Image {
+		transformedReference = image.DockerImageReference
+		delete(image.Labels, "internal")
+		return image
+	}))
+
+	image, err := is.GetImageOfImageStream(ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "docker.io/library/busybox@" + dgst.String(); transformedReference != expected {
+		t.Errorf("transform got reference %q, want %q", transformedReference, expected)
+	}
+	if _, ok := image.Labels["internal"]; ok {
+		t.Errorf("the transform has not been applied")
+	}
+	if _, ok := images[dgst].Labels["internal"]; !ok {
+		t.Errorf("the image returned by the getter has been mutated")
+	}
+}
+
+func TestResolveUpstreamRefNestedManifestLists(t *testing.T) {
+	const (
+		index    = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		nested   = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		manifest = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "latest",
+					Items: []imageapiv1.TagEvent{
+						{Image: index, DockerImageReference: "docker.io/library/busybox@" + index},
+					},
+				},
+			},
+		},
+	}
+	layers := &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			index:  {Manifests: []string{nested}},
+			nested: {Manifests: []string{manifest}},
+		},
+	}
+
+	for _, tc := range []struct {
+		name         string
+		dgst         digest.Digest
+		maxDepth     int
+		expectedRef  string
+		expectedCode string
+	}{
+		{
+			name:        "direct child",
+			dgst:        nested,
+			maxDepth:    1,
+			expectedRef: "docker.io/library/busybox@" + nested,
+		},
+		{
+			name:        "nested child",
+			dgst:        manifest,
+			maxDepth:    2,
+			expectedRef: "docker.io/library/busybox@" + manifest,
+		},
+		{
+			name:         "too deep",
+			dgst:         manifest,
+			maxDepth:     1,
+			expectedCode: ErrImageStreamImageNotFoundCode,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.WithTestLogger(context.Background(), t)
+			is := newTestImageStream(stream)
+			is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = layers
+			is.maxManifestListDepth = tc.maxDepth
+
+			ref, err := is.resolveUpstreamRef(ctx, tc.dgst)
+			if tc.expectedCode != "" {
+				if err == nil || err.Code() != tc.expectedCode {
+					t.Fatalf("got error %v, want code %s", err, tc.expectedCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ref.Exact() != tc.expectedRef {
+				t.Errorf("got reference %q, want %q", ref.Exact(), tc.expectedRef)
+			}
+		})
+	}
+}
+
+func TestCreateImageStreamMappingProtectedTag(t *testing.T) {
+	const (
+		current = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		pushed  = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "stable", Items: []imageapiv1.TagEvent{{Image: current}}},
+			},
+		},
+	}
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+	is := newTestImageStream(stream)
+	WithProtectedTags("stable")(is)
+
+	err := is.CreateImageStreamMapping(ctx, nil, "stable", &imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: pushed}})
+	if err == nil || err.Code() != ErrImageStreamForbiddenCode {
+		t.Fatalf("got error %v, want code %s", err, ErrImageStreamForbiddenCode)
+	}
+
+	if err := is.checkProtectedTag(ctx, "stable", current); err != nil {
+		t.Errorf("pushing the current image: unexpected error: %v", err)
+	}
+	if err := is.checkProtectedTag(WithProtectedTagOverride(ctx), "stable", pushed); err != nil {
+		t.Errorf("override: unexpected error: %v", err)
+	}
+	if err := is.checkProtectedTag(ctx, "latest", pushed); err != nil {
+		t.Errorf("unprotected tag: unexpected error: %v", err)
+	}
+}
+
+func TestSplitRepositoriesByAuth(t *testing.T) {
+	secrets := []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pull-secret"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"private.example.org":{"auth":"dXNlcjpwYXNz"}}}`),
+			},
+		},
+	}
+
+	authenticated, anonymous, err := SplitRepositoriesByAuth([]string{
+		"private.example.org/team/app",
+		"docker.io/library/busybox",
+		"private.example.org/team/db",
+	}, secrets, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"private.example.org/team/app", "private.example.org/team/db"}; !reflect.DeepEqual(authenticated, expected) {
+		t.Errorf("got authenticated %v, want %v", authenticated, expected)
+	}
+	if expected := []string{"docker.io/library/busybox"}; !reflect.DeepEqual(anonymous, expected) {
+		t.Errorf("got anonymous %v, want %v", anonymous, expected)
+	}
+
+	installKeyring := &credentialprovider.BasicDockerKeyring{}
+	installKeyring.Add(credentialprovider.DockerConfig{
+		"install.example.org": credentialprovider.DockerConfigEntry{Username: "user", Password: "pass"},
+	})
+
+	authenticated, anonymous, err = SplitRepositoriesByAuth([]string{
+		"private.example.org/team/app",
+		"install.example.org/team/base",
+		"docker.io/library/busybox",
+	}, secrets, installKeyring)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"private.example.org/team/app", "install.example.org/team/base"}; !reflect.DeepEqual(authenticated, expected) {
+		t.Errorf("with default keyring: got authenticated %v, want %v", authenticated, expected)
+	}
+	if expected := []string{"docker.io/library/busybox"}; !reflect.DeepEqual(anonymous, expected) {
+		t.Errorf("with default keyring: got anonymous %v, want %v", anonymous, expected)
+	}
+}
+
+func TestInvalidSecretNames(t *testing.T) {
+	secrets := []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "valid"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":{"private.example.org":{"auth":"dXNlcjpwYXNz"}}}`),
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "broken-json"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths":`),
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "broken-dockercfg"},
+			Type:       corev1.SecretTypeDockercfg,
+			Data: map[string][]byte{
+				corev1.DockerConfigKey: []byte(`[]`),
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque"},
+			Type:       corev1.SecretTypeOpaque,
+		},
+	}
+
+	if names, expected := InvalidSecretNames(secrets), []string{"broken-json", "broken-dockercfg"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("got %v, want %v", names, expected)
+	}
+}
+
+func TestResolveCrossStreamTag(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+	fos, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+
+	istagRef := func(name string) *corev1.ObjectReference {
+		return &corev1.ObjectReference{Kind: "ImageStreamTag", Name: name}
+	}
+
+	for _, stream := range []*imageapiv1.ImageStream{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "app"},
+			Spec: imageapiv1.ImageStreamSpec{
+				Tags: []imageapiv1.TagReference{
+					{Name: "prod", From: istagRef("release:v1")},
+					{Name: "latest", From: istagRef("prod")},
+					{Name: "a", From: istagRef("b")},
+					{Name: "b", From: istagRef("app:a")},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "release"},
+			Status: imageapiv1.ImageStreamStatus{
+				Tags: []imageapiv1.NamedTagEventList{
+					{Tag: "v1", Items: []imageapiv1.TagEvent{{Image: dgst}}},
+				},
+			},
+		},
+	} {
+		if _, err := fos.CreateImageStream("ns", stream); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	is := New(ctx, "ns", "app", client.NewFakeRegistryAPIClient(nil, imageClient))
+
+	for _, tag := range []string{"prod", "latest"} {
+		tagEvent, err := is.ResolveCrossStreamTag(ctx, tag)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tag, err)
+		}
+		if tagEvent.Image != dgst {
+			t.Errorf("%s: got image %s, want %s", tag, tagEvent.Image, dgst)
+		}
+	}
+
+	if _, err := is.ResolveCrossStreamTag(ctx, "a"); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("cycle: got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
+
+func TestResolveCrossStreamTagWithoutClient(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	app := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app"},
+		Spec: imageapiv1.ImageStreamSpec{
+			Tags: []imageapiv1.TagReference{
+				{Name: "prod", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "release:v1"}},
+			},
+		},
+	}
+	release := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "release"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "v1", Items: []imageapiv1.TagEvent{{Image: dgst}}},
+			},
+		},
+	}
+
+	is := NewWithGetters(ctx, "ns", "app", nil, fakeImageGetter{}, newTestImageStream(app).imageStreamGetter)
+	if _, err := is.ResolveCrossStreamTag(ctx, "prod"); err == nil || err.Code() != ErrImageStreamNotFoundCode {
+		t.Errorf("without client: got error %v, want code %s", err, ErrImageStreamNotFoundCode)
+	}
+
+	factory := func(namespace, name string) ImageStreamGetter {
+		return newTestImageStream(release).imageStreamGetter
+	}
+	is = NewWithGetters(ctx, "ns", "app", nil, fakeImageGetter{}, newTestImageStream(app).imageStreamGetter, WithImageStreamGetterFactory(factory))
+	tagEvent, err := is.ResolveCrossStreamTag(ctx, "prod")
+	if err != nil {
+		t.Fatalf("with factory: unexpected error: %v", err)
+	}
+	if tagEvent.Image != dgst {
+		t.Errorf("with factory: got image %s, want %s", tagEvent.Image, dgst)
+	}
+}
+
+func TestValidateTagSource(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+	fos, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+
+	for _, stream := range []*imageapiv1.ImageStream{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "app"},
+			Spec: imageapiv1.ImageStreamSpec{
+				Tags: []imageapiv1.TagReference{
+					{Name: "release", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "release:v1"}},
+					{Name: "missing-tag", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "release:v2"}},
+					{Name: "missing-stream", From: &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "unknown:v1"}},
+					{Name: "external", From: &corev1.ObjectReference{Kind: "DockerImage", Name: "registry.example.org/ns/is:latest"}},
+					{Name: "invalid", From: &corev1.ObjectReference{Kind: "DockerImage", Name: "Invalid Reference"}},
+					{Name: "none"},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "release"},
+			Status: imageapiv1.ImageStreamStatus{
+				Tags: []imageapiv1.NamedTagEventList{
+					{Tag: "v1", Items: []imageapiv1.TagEvent{{Image: dgst}}},
+				},
+			},
+		},
+	} {
+		if _, err := fos.CreateImageStream("ns", stream); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	is := New(ctx, "ns", "app", client.NewFakeRegistryAPIClient(nil, imageClient))
+
+	for _, tc := range []struct {
+		tag  string
+		code string
+	}{
+		{tag: "release"},
+		{tag: "external"},
+		{tag: "missing-tag", code: ErrImageStreamTagSourceNotFoundCode},
+		{tag: "missing-stream", code: ErrImageStreamTagSourceNotFoundCode},
+		{tag: "invalid", code: ErrImageStreamTagSourceInvalidCode},
+		{tag: "none", code: ErrImageStreamTagSourceInvalidCode},
+		{tag: "unknown", code: ErrImageStreamImageNotFoundCode},
+	} {
+		err := is.ValidateTagSource(ctx, tc.tag)
+		if tc.code == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.tag, err)
+			}
+			continue
+		}
+		if err == nil || err.Code() != tc.code {
+			t.Errorf("%s: got error %v, want code %s", tc.tag, err, tc.code)
+		}
+	}
+}
+
+func TestDigestAliases(t *testing.T) {
+	const (
+		stored    = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		requested = digest.Digest("sha512:00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000001")
+	)
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "latest", Items: []imageapiv1.TagEvent{{Image: stored.String()}}},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		stored: {ObjectMeta: metav1.ObjectMeta{Name: stored.String()}},
+	}
+
+	if _, err := is.GetImageOfImageStream(ctx, requested); err == nil {
+		t.Fatalf("without aliases: got no error")
+	}
+
+	WithDigestAliases(map[digest.Digest]digest.Digest{requested: stored})(is)
+
+	image, err := is.GetImageOfImageStream(ctx, requested)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image.Name != stored.String() {
+		t.Errorf("got image %s, want %s", image.Name, stored)
+	}
+}
+
+func TestExternalTags(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			DockerImageRepository:       "image-registry.openshift-image-registry.svc:5000/ns/is",
+			PublicDockerImageRepository: "registry.example.com/ns/is",
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "internal", Items: []imageapiv1.TagEvent{{Image: dgst, DockerImageReference: "image-registry.openshift-image-registry.svc:5000/ns/is@" + dgst}}},
+				{Tag: "public", Items: []imageapiv1.TagEvent{{Image: dgst, DockerImageReference: "registry.example.com/ns/is@" + dgst}}},
+				{Tag: "external", Items: []imageapiv1.TagEvent{{Image: dgst, DockerImageReference: "quay.io/org/app@" + dgst}}},
+				{Tag: "empty"},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	tags, err := is.ExternalTags(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"external": "quay.io/org/app@" + dgst}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("got %v, want %v", tags, expected)
+	}
+}
+
+func TestGetImageByTagWithPolicy(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	dgst := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Spec: imageapiv1.ImageStreamSpec{
+			Tags: []imageapiv1.TagReference{
+				{Name: "local", ReferencePolicy: imageapiv1.TagReferencePolicy{Type: imageapiv1.LocalTagReferencePolicy}},
+				{Name: "source", ReferencePolicy: imageapiv1.TagReferencePolicy{Type: imageapiv1.SourceTagReferencePolicy}},
+			},
+		},
+		Status: imageapiv1.ImageStreamStatus{
+			DockerImageRepository: "image-registry.openshift-image-registry.svc:5000/ns/is",
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "local", Items: []imageapiv1.TagEvent{{Image: dgst.String(), DockerImageReference: "docker.io/library/busybox@" + dgst.String()}}},
+				{Tag: "source", Items: []imageapiv1.TagEvent{{Image: dgst.String(), DockerImageReference: "docker.io/library/busybox@" + dgst.String()}}},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		dgst: {ObjectMeta: metav1.ObjectMeta{Name: dgst.String()}},
+	}
+
+	for _, tc := range []struct {
+		tag              string
+		honorLocalPolicy bool
+		expected         string
+	}{
+		{tag: "local", honorLocalPolicy: true, expected: "image-registry.openshift-image-registry.svc:5000/ns/is@" + dgst.String()},
+		{tag: "local", honorLocalPolicy: false, expected: "docker.io/library/busybox@" + dgst.String()},
+		{tag: "source", honorLocalPolicy: true, expected: "docker.io/library/busybox@" + dgst.String()},
+	} {
+		image, err := is.GetImageByTagWithPolicy(ctx, tc.tag, tc.honorLocalPolicy)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.tag, err)
+		}
+		if image.DockerImageReference != tc.expected {
+			t.Errorf("%s (honorLocalPolicy=%t): got reference %q, want %q", tc.tag, tc.honorLocalPolicy, image.DockerImageReference, tc.expected)
+		}
+	}
+
+	if _, err := is.GetImageByTagWithPolicy(ctx, "missing", true); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("missing: got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
+
+func TestMirrorResolver(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	mirrored := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+	other := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "a", Items: []imageapiv1.TagEvent{{Image: mirrored.String(), DockerImageReference: "docker.io/library/a@" + mirrored.String()}}},
+				{Tag: "b", Items: []imageapiv1.TagEvent{{Image: other.String(), DockerImageReference: "docker.io/library/b@" + other.String()}}},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	WithMirrorResolver(func(dgst digest.Digest) (reference.DockerImageReference, bool) {
+		if dgst != mirrored {
+			return reference.DockerImageReference{}, false
+		}
+		return reference.DockerImageReference{Registry: "mirror.example.com", Namespace: "library", Name: "a", ID: dgst.String()}, true
+	})(is)
+
+	repositoryCandidates, search, err := is.IdentifyCandidateRepositories(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repositoryCandidates) != 3 || repositoryCandidates[0] != "mirror.example.com/library/a" {
+		t.Fatalf("got candidates %v, want mirror.example.com/library/a to be the first of 3", repositoryCandidates)
+	}
+	if _, ok := search["mirror.example.com/library/a"]; !ok {
+		t.Errorf("the mirror is missing in %v", search)
+	}
+}
+
+func TestCanonicalTag(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	istagRef := func(name string) *corev1.ObjectReference {
+		return &corev1.ObjectReference{Kind: "ImageStreamTag", Name: name}
+	}
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Spec: imageapiv1.ImageStreamSpec{
+			Tags: []imageapiv1.TagReference{
+				{Name: "latest", From: istagRef("stable")},
+				{Name: "stable", From: istagRef("is:v1.2.3")},
+				{Name: "other", From: istagRef("other-is:v1")},
+				{Name: "a", From: istagRef("b")},
+				{Name: "b", From: istagRef("a")},
+			},
+		},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "v1.2.3", Items: []imageapiv1.TagEvent{{Image: dgst}}},
+				{Tag: "other", Items: []imageapiv1.TagEvent{{Image: dgst}}},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		tag      string
+		expected string
+	}{
+		{tag: "latest", expected: "v1.2.3"},
+		{tag: "v1.2.3", expected: "v1.2.3"},
+		{tag: "other", expected: "other"},
+	} {
+		tag, err := is.CanonicalTag(ctx, tc.tag)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.tag, err)
+		}
+		if tag != tc.expected {
+			t.Errorf("%s: got %s, want %s", tc.tag, tag, tc.expected)
+		}
+	}
+
+	if _, err := is.CanonicalTag(ctx, "a"); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("cycle: got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
+
+func TestResolveTagAt(t *testing.T) {
+	const (
+		dgst1 = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		dgst2 = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+	)
+
+	t1 := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(24 * time.Hour)
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "latest",
+					Items: []imageapiv1.TagEvent{
+						{Image: dgst2, Created: metav1.NewTime(t2)},
+						{Image: dgst1, Created: metav1.NewTime(t1)},
+					},
+				},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		at       time.Time
+		expected string
+	}{
+		{at: t1, expected: dgst1},
+		{at: t1.Add(time.Hour), expected: dgst1},
+		{at: t2, expected: dgst2},
+		{at: t2.Add(time.Hour), expected: dgst2},
+	} {
+		event, err := is.ResolveTagAt(ctx, "latest", tc.at)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.at, err)
+		}
+		if event.Image != tc.expected {
+			t.Errorf("%s: got %s, want %s", tc.at, event.Image, tc.expected)
+		}
+	}
+
+	if _, err := is.ResolveTagAt(ctx, "latest", t1.Add(-time.Hour)); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("before the first image: got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+	if _, err := is.ResolveTagAt(ctx, "missing", t2); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("missing tag: got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
+
+func TestCreateImageStreamMappingConflict(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	for _, tc := range []struct {
+		name            string
+		conflicts       int
+		refetchFails    bool
+		expectedCode    string
+		expectedCreates int
+	}{
+		{name: "retried", conflicts: maxImageStreamMappingConflictRetries, expectedCreates: maxImageStreamMappingConflictRetries + 1},
+		{name: "exhausted", conflicts: maxImageStreamMappingConflictRetries + 1, expectedCode: ErrImageStreamConflictCode, expectedCreates: maxImageStreamMappingConflictRetries + 1},
+		{name: "refetch fails", conflicts: 1, refetchFails: true, expectedCode: ErrImageStreamConflictCode, expectedCreates: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.WithTestLogger(context.Background(), t)
+			fos, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+			osClient := client.NewFakeRegistryAPIClient(nil, imageClient)
+
+			if _, err := fos.CreateImageStream("ns", &imageapiv1.ImageStream{ObjectMeta: metav1.ObjectMeta{Name: "is"}}); err != nil {
+				t.Fatal(err)
+			}
+			image, err := fos.CreateImage(&imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: dgst}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conflicts := tc.conflicts
+			creates := 0
+			imageClient.PrependReactor("create", "imagestreammappings", func(action core.Action) (bool, runtime.Object, error) {
+				creates++
+				if conflicts == 0 {
+					return false, nil, nil
+				}
+				conflicts--
+				return true, nil, kerrors.NewConflict(imageapiv1.Resource("imagestreammappings"), "is", nil)
+			})
+			imageClient.PrependReactor("get", "imagestreams", func(action core.Action) (bool, runtime.Object, error) {
+				if tc.refetchFails && creates > 0 {
+					return true, nil, kerrors.NewServiceUnavailable("unavailable")
+				}
+				return false, nil, nil
+			})
+
+			is := New(ctx, "ns", "is", osClient)
+			rErr := is.CreateImageStreamMapping(ctx, osClient, "latest", image)
+			switch {
+			case tc.expectedCode == "" && rErr != nil:
+				t.Fatalf("unexpected error: %v", rErr)
+			case tc.expectedCode != "" && (rErr == nil || rErr.Code() != tc.expectedCode):
+				t.Fatalf("got error %v, want code %s", rErr, tc.expectedCode)
+			}
+			if creates != tc.expectedCreates {
+				t.Errorf("got %d attempts to create the mapping, want %d", creates, tc.expectedCreates)
+			}
+		})
+	}
+}
+
+func TestCreateImageStreamMappingAlreadyExists(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+	fos, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+	osClient := client.NewFakeRegistryAPIClient(nil, imageClient)
+
+	// The image stream is created concurrently after the first mapping has
+	// been rejected, so auto provisioning fails with AlreadyExists.
+	concurrent := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Name: "is", Annotations: map[string]string{"concurrent": "true"}},
+	}
+	if _, err := fos.CreateImageStream("ns", concurrent); err != nil {
+		t.Fatal(err)
+	}
+	image, err := fos.CreateImage(&imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: dgst}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := false
+	imageClient.PrependReactor("create", "imagestreammappings", func(action core.Action) (bool, runtime.Object, error) {
+		if rejected {
+			return false, nil, nil
+		}
+		rejected = true
+		return true, nil, kerrors.NewNotFound(imageapiv1.Resource("imagestreammappings"), "is")
+	})
+
+	var sink recordingAuditSink
+	is := New(ctx, "ns", "is", osClient, WithAuditSink(&sink)).(*imageStream)
+	if err := is.CreateImageStreamMapping(ctx, osClient, "latest", image); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sink) != 1 || !sink[0].AutoProvisioned {
+		t.Errorf("got records %#v, want one record of a mapping created after auto provisioning", sink)
+	}
+
+	cached := is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStream
+	if cached == nil || cached.Annotations["concurrent"] != "true" {
+		t.Errorf("got cached image stream %#v, want the concurrently created one", cached)
+	}
+}
+
+func TestCreateImageStreamMappingProvisionRetryFailed(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+	fos, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+	osClient := client.NewFakeRegistryAPIClient(nil, imageClient)
+
+	image, err := fos.CreateImage(&imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: dgst}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := 0
+	imageClient.PrependReactor("create", "imagestreammappings", func(action core.Action) (bool, runtime.Object, error) {
+		posts++
+		if posts == 1 {
+			return true, nil, kerrors.NewNotFound(imageapiv1.Resource("imagestreammappings"), "is")
+		}
+		return true, nil, kerrors.NewInternalError(fmt.Errorf("injected"))
+	})
+
+	is := New(ctx, "ns", "is", osClient)
+	rErr := is.CreateImageStreamMapping(ctx, osClient, "latest", image)
+	if rErr == nil || rErr.Code() != ErrImageStreamProvisionRetryFailedCode {
+		t.Fatalf("got error %v, want code %s", rErr, ErrImageStreamProvisionRetryFailedCode)
+	}
+	if posts != 2 {
+		t.Errorf("got %d posted mappings, want 2", posts)
+	}
+}
+
+func TestAutoProvisionedAnnotation(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		expected bool
+	}{
+		{name: "default", expected: true},
+		{name: "enabled", opts: []Option{WithAutoProvisionedAnnotation(true)}, expected: true},
+		{name: "disabled", opts: []Option{WithAutoProvisionedAnnotation(false)}, expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.WithTestLogger(context.Background(), t)
+			fos, imageClient := testutil.NewFakeOpenShiftWithClient(ctx)
+			osClient := client.NewFakeRegistryAPIClient(nil, imageClient)
+
+			image, err := fos.CreateImage(&imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: dgst}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var created *imageapiv1.ImageStream
+			imageClient.PrependReactor("create", "imagestreams", func(action core.Action) (bool, runtime.Object, error) {
+				created = action.(core.CreateAction).GetObject().(*imageapiv1.ImageStream).DeepCopy()
+				return false, nil, nil
+			})
+
+			is := New(ctx, "ns", "is", osClient, tc.opts...)
+			if err := is.CreateImageStreamMapping(ctx, osClient, "latest", image); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if created == nil {
+				t.Fatal("the image stream has not been auto provisioned")
+			}
+			value, ok := created.Annotations[AutoProvisionedAnnotation]
+			if tc.expected && value != "true" {
+				t.Errorf("got annotations %v, want %s=true", created.Annotations, AutoProvisionedAnnotation)
+			}
+			if !tc.expected && ok {
+				t.Errorf("got annotations %v, want no %s", created.Annotations, AutoProvisionedAnnotation)
+			}
+		})
+	}
+}
+
+func TestTagsSortedSemver(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{},
+		},
+	}
+	for _, tag := range []string{"latest", "v1.10.0", "v1.2.0", "1.9.1", "v2.0.0-rc.1", "stable", "empty"} {
+		history := imageapiv1.NamedTagEventList{Tag: tag}
+		if tag != "empty" {
+			history.Items = []imageapiv1.TagEvent{{Image: dgst}}
+		}
+		stream.Status.Tags = append(stream.Status.Tags, history)
+	}
+
+	is := newTestImageStream(stream)
+	ctx := context.Background()
+
+	tags, err := is.TagsSortedSemver(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"v1.2.0", "1.9.1", "v1.10.0", "v2.0.0-rc.1", "latest", "stable"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("got %v, want %v", tags, expected)
+	}
+
+	event, tag, err := is.ResolveHighestSemverTag(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tag != "v2.0.0-rc.1" || event.Image != dgst {
+		t.Errorf("got tag %s with image %s, want v2.0.0-rc.1 with image %s", tag, event.Image, dgst)
+	}
+
+	stream.Status.Tags = stream.Status.Tags[:1]
+	if _, _, err := is.ResolveHighestSemverTag(ctx); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
+
+func TestCandidatesForDigest(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		dgst1 = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		dgst2 = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		dgst3 = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "a",
+					Items: []imageapiv1.TagEvent{
+						{Image: dgst2, DockerImageReference: "quay.io/new/a@" + dgst2},
+						{Image: dgst1, DockerImageReference: "docker.io/old/a@" + dgst1},
+					},
+				},
+				{Tag: "b", Items: []imageapiv1.TagEvent{{Image: dgst3, DockerImageReference: "docker.io/library/b@" + dgst3}}},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+
+	specs, err := is.CandidatesForDigest(ctx, dgst1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var repos []string
+	for _, spec := range specs {
+		repos = append(repos, spec.DockerImageReference.AsRepository().Exact())
+	}
+	if expected := []string{"docker.io/old/a"}; !reflect.DeepEqual(repos, expected) {
+		t.Errorf("got %v, want %v", repos, expected)
+	}
+
+	mirror, _ := reference.Parse("mirror.example.com/a@" + dgst1)
+	is.mirrorResolver = func(dgst digest.Digest) (reference.DockerImageReference, bool) {
+		return mirror, dgst == dgst1
+	}
+	is.candidateRanker = func(specs []ImagePullthroughSpec) []ImagePullthroughSpec {
+		ranked := make([]ImagePullthroughSpec, 0, len(specs))
+		for i := len(specs) - 1; i >= 0; i-- {
+			ranked = append(ranked, specs[i])
+		}
+		return ranked
+	}
+	specs, err = is.CandidatesForDigest(ctx, dgst1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repos = nil
+	for _, spec := range specs {
+		repos = append(repos, spec.DockerImageReference.AsRepository().Exact())
+	}
+	if expected := []string{"docker.io/old/a", "mirror.example.com/a"}; !reflect.DeepEqual(repos, expected) {
+		t.Errorf("with mirrors and ranker: got %v, want %v", repos, expected)
+	}
+
+	if _, err := is.CandidatesForDigest(ctx, "sha256:0000000000000000000000000000000000000000000000000000000000000004"); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
+
+func TestDetectTagCycles(t *testing.T) {
+	istagRef := func(name string) *corev1.ObjectReference {
+		return &corev1.ObjectReference{Kind: "ImageStreamTag", Name: name}
+	}
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Spec: imageapiv1.ImageStreamSpec{
+			Tags: []imageapiv1.TagReference{
+				{Name: "latest", From: istagRef("stable")},
+				{Name: "stable", From: istagRef("v1")},
+				{Name: "z", From: istagRef("is:x")},
+				{Name: "x", From: istagRef("y")},
+				{Name: "y", From: istagRef("z")},
+				{Name: "entry", From: istagRef("y")},
+				{Name: "self", From: istagRef("self")},
+				{Name: "other", From: istagRef("other-is:other")},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+
+	cycles, err := is.DetectTagCycles(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := [][]string{{"self"}, {"x", "y", "z"}}; !reflect.DeepEqual(cycles, expected) {
+		t.Errorf("got %v, want %v", cycles, expected)
+	}
+}
+
+func TestGetImageWithSource(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		index    = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		manifest = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "latest",
+					Items: []imageapiv1.TagEvent{
+						{Image: index.String(), DockerImageReference: "docker.io/library/busybox@" + index.String()},
+					},
+				},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		index:    {ObjectMeta: metav1.ObjectMeta{Name: index.String()}},
+		manifest: {ObjectMeta: metav1.ObjectMeta{Name: manifest.String()}},
+	}
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			index.String(): {Manifests: []string{manifest.String()}},
+		},
+	}
+
+	for _, tc := range []struct {
+		dgst     digest.Digest
+		expected ImageSource
+	}{
+		{dgst: index, expected: ImageSourceLocal},
+		{dgst: manifest, expected: ImageSourceUpstream},
+	} {
+		image, source, err := is.GetImageWithSource(ctx, tc.dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.dgst, err)
+		}
+		if source != tc.expected {
+			t.Errorf("%s: got source %s, want %s", tc.dgst, source, tc.expected)
+		}
+		if expected := "docker.io/library/busybox@" + tc.dgst.String(); image.DockerImageReference != expected {
+			t.Errorf("%s: got reference %q, want %q", tc.dgst, image.DockerImageReference, expected)
+		}
+	}
+}
+
+func TestGetImageIfAllowed(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		hub    = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		quay   = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+		pushed = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000003")
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			DockerImageRepository: "image-registry.openshift-image-registry.svc:5000/ns/is",
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "hub", Items: []imageapiv1.TagEvent{{Image: hub.String(), DockerImageReference: "busybox@" + hub.String()}}},
+				{Tag: "quay", Items: []imageapiv1.TagEvent{{Image: quay.String(), DockerImageReference: "quay.io/acme/app@" + quay.String()}}},
+				{Tag: "pushed", Items: []imageapiv1.TagEvent{{Image: pushed.String(), DockerImageReference: "image-registry.openshift-image-registry.svc:5000/ns/is@" + pushed.String()}}},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		hub:    {ObjectMeta: metav1.ObjectMeta{Name: hub.String()}},
+		quay:   {ObjectMeta: metav1.ObjectMeta{Name: quay.String()}},
+		pushed: {ObjectMeta: metav1.ObjectMeta{Name: pushed.String()}},
+	}
+
+	allowed := []string{"docker.io"}
+	for _, dgst := range []digest.Digest{hub, pushed} {
+		if _, err := is.GetImageIfAllowed(ctx, dgst, allowed); err != nil {
+			t.Errorf("%s: unexpected error: %v", dgst, err)
+		}
+	}
+	if _, err := is.GetImageIfAllowed(ctx, quay, allowed); err == nil || err.Code() != ErrImageStreamForbiddenCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamForbiddenCode)
+	}
+}
+
+func TestCommonAncestor(t *testing.T) {
+	const (
+		base   = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		fix    = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		canary = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+		stable = "sha256:0000000000000000000000000000000000000000000000000000000000000004"
+	)
+
+	at := func(day int) metav1.Time {
+		return metav1.NewTime(time.Date(2020, time.January, day, 0, 0, 0, 0, time.UTC))
+	}
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "canary",
+					Items: []imageapiv1.TagEvent{
+						{Image: canary, Created: at(4)},
+						{Image: fix, Created: at(2)},
+						{Image: base, Created: at(1)},
+					},
+				},
+				{
+					Tag: "stable",
+					Items: []imageapiv1.TagEvent{
+						{Image: stable, Created: at(5)},
+						{Image: base, Created: at(3)},
+						{Image: fix, Created: at(2)},
+					},
+				},
+				{Tag: "other", Items: []imageapiv1.TagEvent{{Image: stable, Created: at(5)}}},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	ctx := context.Background()
+
+	dgst, ok, err := is.CommonAncestor(ctx, "canary", "stable")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok || dgst != base {
+		t.Errorf("got %s, %t, want %s, true", dgst, ok, base)
+	}
+
+	if _, ok, err := is.CommonAncestor(ctx, "canary", "other"); err != nil || ok {
+		t.Errorf("got %t, %v, want false, nil", ok, err)
+	}
+
+	if _, _, err := is.CommonAncestor(ctx, "canary", "missing"); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
+
+// fakeLimitRangesClient serves LimitRanges from list and counts the calls.
+type fakeLimitRangesClient struct {
+	client.Interface
+
+	list  *corev1.LimitRangeList
+	lists int
+}
+
+func (c *fakeLimitRangesClient) LimitRanges(namespace string) client.LimitRangeInterface {
+	return c
+}
+
+func (c *fakeLimitRangesClient) List(ctx context.Context, opts metav1.ListOptions) (*corev1.LimitRangeList, error) {
+	c.lists++
+	return c.list, nil
+}
+
+// fakeTimestampedStore is a TimestampedProjectObjectListStore that reports
+// added as the time when its entries have been added.
+type fakeTimestampedStore struct {
+	objects map[string]runtime.Object
+	added   time.Time
+}
+
+func (s *fakeTimestampedStore) Add(namespace string, obj runtime.Object) error {
+	s.objects[namespace] = obj
+	s.added = time.Now()
+	return nil
+}
+
+func (s *fakeTimestampedStore) Get(namespace string) (runtime.Object, bool, error) {
+	obj, ok := s.objects[namespace]
+	return obj, ok, nil
+}
+
+func (s *fakeTimestampedStore) GetWithTimestamp(namespace string) (runtime.Object, time.Time, bool, error) {
+	obj, ok := s.objects[namespace]
+	return obj, s.added, ok, nil
+}
+
+func TestGetLimitRangeListWithClock(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	cached := &corev1.LimitRangeList{Items: []corev1.LimitRange{{ObjectMeta: metav1.ObjectMeta{Name: "cached"}}}}
+	store := &fakeTimestampedStore{objects: make(map[string]runtime.Object)}
+	if err := store.Add("ns", cached); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := &corev1.LimitRangeList{Items: []corev1.LimitRange{{ObjectMeta: metav1.ObjectMeta{Name: "fresh"}}}}
+	osClient := &fakeLimitRangesClient{list: fresh}
+	clock := func() time.Time { return time.Now().Add(time.Hour) }
+	is := NewWithGetters(ctx, "ns", "is", osClient, fakeImageGetter{}, nil, WithClock(clock))
+
+	lrs, err := is.GetLimitRangeList(ctx, store, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lrs != fresh {
+		t.Errorf("got %v, want the fetched limit range list", lrs)
+	}
+	if osClient.lists != 1 {
+		t.Errorf("got %d list calls, want the entry to be stale according to the clock", osClient.lists)
+	}
+}
+
+func TestGetLimitRangeListStaleEntry(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	cached := &corev1.LimitRangeList{Items: []corev1.LimitRange{{ObjectMeta: metav1.ObjectMeta{Name: "cached"}}}}
+	fresh := &corev1.LimitRangeList{Items: []corev1.LimitRange{{ObjectMeta: metav1.ObjectMeta{Name: "fresh"}}}}
+
+	for _, test := range []struct {
+		name       string
+		maxAge     time.Duration
+		want       *corev1.LimitRangeList
+		wantListed int
+	}{
+		{name: "no max age", maxAge: 0, want: cached, wantListed: 0},
+		{name: "entry younger than max age", maxAge: time.Hour, want: cached, wantListed: 0},
+		{name: "entry older than max age", maxAge: time.Minute, want: fresh, wantListed: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			store := &fakeTimestampedStore{
+				objects: map[string]runtime.Object{"ns": cached},
+				added:   time.Now().Add(-2 * time.Minute),
+			}
+			osClient := &fakeLimitRangesClient{list: fresh}
+			is := NewWithGetters(ctx, "ns", "is", osClient, fakeImageGetter{}, nil)
+
+			lrs, err := is.GetLimitRangeList(ctx, store, test.maxAge)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if lrs != test.want {
+				t.Errorf("got limit ranges %v, want %v", lrs.Items, test.want.Items)
+			}
+			if osClient.lists != test.wantListed {
+				t.Errorf("got %d list calls, want %d", osClient.lists, test.wantListed)
+			}
+		})
+	}
+}
+
+func TestRedactedErrors(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "secret-ns", Name: "secret-is"},
+	}
+	getter := newTestImageStream(stream).imageStreamGetter
+
+	for _, test := range []struct {
+		name     string
+		opts     []Option
+		wantCode string
+		redacted bool
+	}{
+		{name: "not redacted", wantCode: ErrImageStreamImageNotFoundCode},
+		{name: "redacted", opts: []Option{WithRedactedErrors()}, wantCode: ErrImageStreamImageNotFoundCode, redacted: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			is := NewWithGetters(ctx, "secret-ns", "secret-is", nil, fakeImageGetter{}, getter, test.opts...)
+
+			for method, get := range map[string]func() rerrors.Error{
+				"GetImageOfImageStream": func() rerrors.Error {
+					_, err := is.GetImageOfImageStream(ctx, dgst)
+					return err
+				},
+				"GetImageIfAllowed": func() rerrors.Error {
+					_, err := is.GetImageIfAllowed(ctx, dgst, nil)
+					return err
+				},
+				"ResolveImageID": func() rerrors.Error {
+					_, err := is.ResolveImageID(ctx, dgst)
+					return err
+				},
+			} {
+				err := get()
+				if err == nil || err.Code() != test.wantCode {
+					t.Fatalf("%s: got error %v, want code %s", method, err, test.wantCode)
+				}
+				leaks := strings.Contains(err.Error(), "secret-is") || strings.Contains(err.Error(), dgst)
+				if test.redacted && (leaks || err.Unwrap() != nil) {
+					t.Errorf("%s: got error %q with cause %v, want no image stream, digest or cause", method, err, err.Unwrap())
+				}
+				if !test.redacted && !leaks {
+					t.Errorf("%s: got error %q, want the detailed error", method, err)
+				}
+			}
+		})
+	}
+}
+
+func TestImageInStream(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		tagged  = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		member  = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+		pruned  = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000003")
+		unknown = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000004")
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag:   "latest",
+					Items: []imageapiv1.TagEvent{{Image: tagged.String(), DockerImageReference: "docker.io/library/busybox@" + tagged.String()}},
+				},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			tagged.String(): {Manifests: []string{member.String()}},
+			pruned.String(): {},
+		},
+	}
+
+	for _, test := range []struct {
+		dgst digest.Digest
+		want bool
+	}{
+		{dgst: tagged, want: true},
+		{dgst: member, want: true},
+		{dgst: pruned, want: true},
+		{dgst: unknown, want: false},
+	} {
+		ok, err := is.ImageInStream(ctx, test.dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.dgst, err)
+		}
+		if ok != test.want {
+			t.Errorf("%s: got %t, want %t", test.dgst, ok, test.want)
+		}
+	}
+}
+
+func TestTagsStrictDigestParsing(t *testing.T) {
+	const valid = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	for _, tc := range []struct {
+		name  string
+		image string
+	}{
+		{name: "empty", image: ""},
+		{name: "no algorithm", image: "0000000000000000000000000000000000000000000000000000000000000001"},
+		{name: "no hex", image: "sha256:"},
+		{name: "short hex", image: "sha256:0001"},
+		{name: "long hex", image: valid + "0"},
+		{name: "upper case hex", image: "sha256:000000000000000000000000000000000000000000000000000000000000000A"},
+		{name: "non hex", image: "sha256:000000000000000000000000000000000000000000000000000000000000000z"},
+		{name: "unknown algorithm", image: "md5:00000000000000000000000000000001"},
+		{name: "image name", image: "docker.io/library/busybox:latest"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := &imageapiv1.ImageStream{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+				Status: imageapiv1.ImageStreamStatus{
+					Tags: []imageapiv1.NamedTagEventList{
+						{Tag: "good", Items: []imageapiv1.TagEvent{{Image: valid}}},
+						{Tag: "bad", Items: []imageapiv1.TagEvent{{Image: tc.image}}},
+					},
+				},
+			}
+
+			is := newTestImageStream(stream)
+			tags, err := is.Tags(ctx)
+			if err != nil {
+				t.Fatalf("lenient: unexpected error: %v", err)
+			}
+			if expected := map[string]digest.Digest{"good": valid}; !reflect.DeepEqual(tags, expected) {
+				t.Errorf("lenient: got %v, want %v", tags, expected)
+			}
+
+			is = newTestImageStream(stream)
+			WithStrictDigestParsing()(is)
+			if _, err := is.Tags(ctx); err == nil || err.Code() != ErrImageStreamInvalidDigestCode {
+				t.Errorf("strict: got error %v, want code %s", err, ErrImageStreamInvalidDigestCode)
+			}
+		})
+	}
+}
+
+// fakeAccessReviewClient answers local subject access reviews with allowed or
+// err, records the last review and counts the reviews.
+type fakeAccessReviewClient struct {
+	client.Interface
+
+	allowed bool
+	err     error
+	review  *authorizationapi.LocalSubjectAccessReview
+	reviews int
+}
+
+func (c *fakeAccessReviewClient) LocalSubjectAccessReviews(namespace string) client.LocalSubjectAccessReviewInterface {
+	return c
+}
+
+func (c *fakeAccessReviewClient) Create(ctx context.Context, review *authorizationapi.LocalSubjectAccessReview, opts metav1.CreateOptions) (*authorizationapi.LocalSubjectAccessReview, error) {
+	c.review = review
+	c.reviews++
+	if c.err != nil {
+		return nil, c.err
+	}
+	response := review.DeepCopy()
+	response.Status.Allowed = c.allowed
+	return response, nil
+}
+
+func TestAllowsAnonymousPullWithoutClient(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+	is := NewWithGetters(ctx, "ns", "is", nil, fakeImageGetter{}, nil)
+
+	allowed, err := is.AllowsAnonymousPull(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if allowed {
+		t.Errorf("got anonymous pulls allowed without a client")
+	}
+}
+
+func TestAllowsAnonymousPull(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		allowed  bool
+		err      error
+		want     bool
+		wantCode string
+	}{
+		{
+			name:    "allowed",
+			allowed: true,
+			want:    true,
+		},
+		{
+			name: "denied",
+		},
+		{
+			name:     "forbidden",
+			err:      kerrors.NewForbidden(authorizationapi.Resource("localsubjectaccessreviews"), "", fmt.Errorf("denied")),
+			wantCode: ErrImageStreamForbiddenCode,
+		},
+		{
+			name:     "unknown error",
+			err:      fmt.Errorf("connection refused"),
+			wantCode: ErrImageStreamUnknownErrorCode,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testutil.WithTestLogger(context.Background(), t)
+
+			osClient := &fakeAccessReviewClient{allowed: test.allowed, err: test.err}
+			is := NewWithGetters(ctx, "ns", "is", osClient, fakeImageGetter{}, nil)
+
+			allowed, err := is.AllowsAnonymousPull(ctx)
+			if test.wantCode != "" {
+				if err == nil || err.Code() != test.wantCode {
+					t.Fatalf("got error %v, want code %s", err, test.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if allowed != test.want {
+				t.Errorf("got %t, want %t", allowed, test.want)
+			}
+
+			if allowed, err := is.AllowsAnonymousPull(ctx); err != nil || allowed != test.want {
+				t.Errorf("got %t, %v on the second call, want %t", allowed, err, test.want)
+			}
+			if osClient.reviews != 1 {
+				t.Errorf("got %d reviews, want the answer to be cached", osClient.reviews)
+			}
+
+			spec := osClient.review.Spec
+			if spec.User != "system:anonymous" || !reflect.DeepEqual(spec.Groups, []string{"system:unauthenticated"}) {
+				t.Errorf("got review for user %q in groups %v, want the anonymous user", spec.User, spec.Groups)
+			}
+			want := authorizationapi.ResourceAttributes{
+				Namespace: "ns",
+				Verb:      "get",
+				Group:     imageapiv1.GroupName,
+				Resource:  "imagestreams/layers",
+				Name:      "is",
+			}
+			if spec.ResourceAttributes == nil || *spec.ResourceAttributes != want {
+				t.Errorf("got resource attributes %+v, want %+v", spec.ResourceAttributes, want)
+			}
+		})
+	}
+}
+
+func TestDigestIsInsecure(t *testing.T) {
+	const (
+		secureImage   = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		insecureImage = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		unknownImage  = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+	)
+
+	newStream := func(annotations map[string]string) *imageapiv1.ImageStream {
+		return &imageapiv1.ImageStream{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is", Annotations: annotations},
+			Spec: imageapiv1.ImageStreamSpec{
+				Tags: []imageapiv1.TagReference{
+					{Name: "secure"},
+					{Name: "insecure", ImportPolicy: imageapiv1.TagImportPolicy{Insecure: true}},
+				},
+			},
+			Status: imageapiv1.ImageStreamStatus{
+				Tags: []imageapiv1.NamedTagEventList{
+					{Tag: "secure", Items: []imageapiv1.TagEvent{{Image: secureImage}}},
+					{Tag: "insecure", Items: []imageapiv1.TagEvent{{Image: insecureImage}}},
+				},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		dgst        digest.Digest
+		expected    bool
+	}{
+		{name: "secure tag", dgst: secureImage, expected: false},
+		{name: "insecure tag", dgst: insecureImage, expected: true},
+		{name: "no tag", dgst: unknownImage, expected: false},
+		{
+			name:        "insecure image stream",
+			annotations: map[string]string{imageapiv1.InsecureRepositoryAnnotation: "true"},
+			dgst:        secureImage,
+			expected:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.WithTestLogger(context.Background(), t)
+			is := newTestImageStream(newStream(tc.annotations))
+
+			insecure, err := is.DigestIsInsecure(ctx, tc.dgst)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if insecure != tc.expected {
+				t.Errorf("got insecure %t, want %t", insecure, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPreferPublic(t *testing.T) {
+	const dgst = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			DockerImageRepository:       "image-registry.openshift-image-registry.svc:5000/ns/is",
+			PublicDockerImageRepository: "registry.example.com/ns/is",
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "latest", Items: []imageapiv1.TagEvent{{Image: dgst, DockerImageReference: "docker.io/library/busybox@" + dgst}}},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		opts       []Option
+		localFirst bool
+		expected   []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"docker.io/library/busybox"},
+		},
+		{
+			name:     "prefer public",
+			opts:     []Option{WithPreferPublic()},
+			expected: []string{"registry.example.com/ns/is", "docker.io/library/busybox"},
+		},
+		{
+			name:       "local first",
+			localFirst: true,
+			expected:   []string{"image-registry.openshift-image-registry.svc:5000/ns/is", "docker.io/library/busybox"},
+		},
+		{
+			name:       "local first and prefer public",
+			opts:       []Option{WithPreferPublic()},
+			localFirst: true,
+			expected:   []string{"registry.example.com/ns/is", "image-registry.openshift-image-registry.svc:5000/ns/is", "docker.io/library/busybox"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.WithTestLogger(context.Background(), t)
+			is := newTestImageStream(stream)
+			for _, opt := range tc.opts {
+				opt(is)
+			}
+
+			identify := is.IdentifyCandidateRepositories
+			if tc.localFirst {
+				identify = is.IdentifyCandidateRepositoriesLocalFirst
+			}
+			repositories, search, err := identify(ctx, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(repositories, tc.expected) {
+				t.Errorf("got candidates %v, want %v", repositories, tc.expected)
+			}
+			if spec, ok := search["registry.example.com/ns/is"]; ok && !spec.LocalFirst {
+				t.Errorf("got public repository spec %+v, want it to be LocalFirst", spec)
+			}
+		})
+	}
+}
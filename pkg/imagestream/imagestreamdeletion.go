@@ -0,0 +1,162 @@
+package imagestream
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+)
+
+// DeletionReport describes the consequences of removing an image from an
+// image stream.
+type DeletionReport struct {
+	// IsTagHead is true if the image is the latest image of any tag.
+	IsTagHead bool
+	// HeadTags are the sorted names of the tags whose latest image is the
+	// image.
+	HeadTags []string
+	// HistoryTags maps the tags whose history contains the image to the
+	// positions of the image in the history, see TagsContainingImage.
+	HistoryTags map[string][]int
+	// ManifestListParents are the sorted digests of the manifest lists of
+	// the image stream that contain the image.
+	ManifestListParents []digest.Digest
+	// ManifestListMembers are the digests of the manifests the image
+	// contains if it is a manifest list.
+	ManifestListMembers []digest.Digest
+	// UnreferencedBlobs are the sorted digests of the layers and the config
+	// of the image that no other image of the image stream references.
+	UnreferencedBlobs []digest.Digest
+}
+
+// DeletionSafety returns a report about the image with the given digest that
+// helps to decide whether it is safe to remove it from the image stream. The
+// report is based on the tag histories and on the image stream layers. If the
+// image is not part of the image stream, a not found error is returned.
+func (is *imageStream) DeletionSafety(ctx context.Context, dgst digest.Digest) (*DeletionReport, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("DeletionSafety: failed to get image stream %s", is.Reference()))
+	}
+	layers, rErr := is.imageStreamGetter.Layers(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("DeletionSafety: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	report := &DeletionReport{
+		HeadTags:    []string{},
+		HistoryTags: make(map[string][]int),
+	}
+
+	for _, history := range stream.Status.Tags {
+		for i, item := range history.Items {
+			if item.Image != dgst.String() {
+				continue
+			}
+			report.HistoryTags[history.Tag] = append(report.HistoryTags[history.Tag], i)
+			if i == 0 {
+				report.HeadTags = append(report.HeadTags, history.Tag)
+			}
+		}
+	}
+	sort.Strings(report.HeadTags)
+	report.IsTagHead = len(report.HeadTags) > 0
+
+	refs, ok := layers.Images[dgst.String()]
+	if !ok && len(report.HistoryTags) == 0 {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("DeletionSafety: image %s not found in image stream %s", dgst.String(), is.Reference()),
+			nil,
+		)
+	}
+
+	for _, manifest := range refs.Manifests {
+		report.ManifestListMembers = append(report.ManifestListMembers, digest.Digest(manifest))
+	}
+
+	for name, image := range layers.Images {
+		if name == dgst.String() {
+			continue
+		}
+		for _, manifest := range image.Manifests {
+			if manifest == dgst.String() {
+				report.ManifestListParents = append(report.ManifestListParents, digest.Digest(name))
+				break
+			}
+		}
+	}
+	sort.Slice(report.ManifestListParents, func(i, j int) bool {
+		return report.ManifestListParents[i] < report.ManifestListParents[j]
+	})
+
+	report.UnreferencedBlobs = uniqueBlobs(layers, dgst)
+
+	return report, nil
+}
+
+// uniqueBlobs returns the sorted digests of the layers and the config of the
+// image dgst that no other image in layers references.
+func uniqueBlobs(layers *imageapiv1.ImageStreamLayers, dgst digest.Digest) []digest.Digest {
+	refs := layers.Images[dgst.String()]
+
+	own := make(map[string]bool, len(refs.Layers)+1)
+	for _, layer := range refs.Layers {
+		own[layer] = true
+	}
+	if refs.Config != nil {
+		own[*refs.Config] = true
+	}
+
+	for name, image := range layers.Images {
+		if name == dgst.String() {
+			continue
+		}
+		for _, layer := range image.Layers {
+			delete(own, layer)
+		}
+		if image.Config != nil {
+			delete(own, *image.Config)
+		}
+	}
+
+	var blobs []digest.Digest
+	for blob := range own {
+		blobs = append(blobs, digest.Digest(blob))
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i] < blobs[j]
+	})
+	return blobs
+}
+
+// UniqueBlobsForImage returns the sorted digests of the layers and the config
+// of the image with the given digest that no other image of the image stream
+// references, i.e. the blobs that removing the image would leave
+// unreferenced. If the image stream layers do not list the image, a not found
+// error is returned.
+func (is *imageStream) UniqueBlobsForImage(ctx context.Context, dgst digest.Digest) ([]digest.Digest, rerrors.Error) {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("UniqueBlobsForImage: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	if _, ok := layers.Images[dgst.String()]; !ok {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("UniqueBlobsForImage: image %s not found in image stream %s", dgst.String(), is.Reference()),
+			nil,
+		)
+	}
+
+	blobs := uniqueBlobs(layers, dgst)
+	if blobs == nil {
+		blobs = []digest.Digest{}
+	}
+	return blobs, nil
+}
@@ -0,0 +1,85 @@
+package imagestream
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+)
+
+func TestDeletionSafety(t *testing.T) {
+	const (
+		index    = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		manifest = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		other    = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+		shared   = "sha256:00000000000000000000000000000000000000000000000000000000000000a1"
+		unique   = "sha256:00000000000000000000000000000000000000000000000000000000000000a2"
+		config   = "sha256:00000000000000000000000000000000000000000000000000000000000000c1"
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "latest", Items: []imageapiv1.TagEvent{{Image: index}, {Image: other}}},
+				{Tag: "stable", Items: []imageapiv1.TagEvent{{Image: other}}},
+			},
+		},
+	}
+
+	configDigest := config
+	is := newTestImageStream(stream)
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			index:    {Manifests: []string{manifest}},
+			manifest: {Layers: []string{shared, unique}, Config: &configDigest},
+			other:    {Layers: []string{shared}},
+		},
+	}
+
+	ctx := context.Background()
+
+	report, err := is.DeletionSafety(ctx, other)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &DeletionReport{
+		IsTagHead:   true,
+		HeadTags:    []string{"stable"},
+		HistoryTags: map[string][]int{"latest": {1}, "stable": {0}},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("got %#v, want %#v", report, expected)
+	}
+
+	report, err = is.DeletionSafety(ctx, manifest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = &DeletionReport{
+		HeadTags:            []string{},
+		HistoryTags:         map[string][]int{},
+		ManifestListParents: []digest.Digest{index},
+		UnreferencedBlobs:   []digest.Digest{unique, config},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("got %#v, want %#v", report, expected)
+	}
+
+	blobs, err := is.UniqueBlobsForImage(ctx, other)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(blobs) != 0 {
+		t.Errorf("got unique blobs %v, want none", blobs)
+	}
+
+	if _, err := is.DeletionSafety(ctx, "sha256:0000000000000000000000000000000000000000000000000000000000000004"); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
@@ -2,12 +2,15 @@ package imagestream
 
 import (
 	"context"
-	"time"
+	"fmt"
+	"sort"
 
 	dcontext "github.com/docker/distribution/context"
 	"github.com/opencontainers/go-digest"
 
 	imageapiv1 "github.com/openshift/api/image/v1"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
 )
 
 // HasBlob returns true if the given blob digest is referenced in image stream corresponding to
@@ -15,9 +18,9 @@ import (
 // oldest until found. Each processed image will update local cache of blobs.
 func (is *imageStream) HasBlob(ctx context.Context, dgst digest.Digest) (bool, *imageapiv1.ImageStreamLayers, *imageapiv1.Image) {
 	dcontext.GetLogger(ctx).Debugf("verifying presence of blob %q in image stream %s", dgst.String(), is.Reference())
-	started := time.Now()
+	started := is.now()
 	logFound := func(found bool, layers *imageapiv1.ImageStreamLayers, image *imageapiv1.Image) (bool, *imageapiv1.ImageStreamLayers, *imageapiv1.Image) {
-		elapsed := time.Since(started)
+		elapsed := is.now().Sub(started)
 		if found {
 			dcontext.GetLogger(ctx).Debugf("verified presence of blob %q in image stream %s after %s", dgst.String(), is.Reference(), elapsed.String())
 		} else {
@@ -27,7 +30,7 @@ func (is *imageStream) HasBlob(ctx context.Context, dgst digest.Digest) (bool, *
 	}
 
 	// perform the more efficient check for a layer in the image stream
-	layers, err := is.imageStreamGetter.layers()
+	layers, err := is.imageStreamGetter.Layers(ctx)
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("imageStream.HasBlob: failed to get image stream layers: %v", err)
 		return logFound(false, nil, nil)
@@ -45,3 +48,231 @@ func (is *imageStream) HasBlob(ctx context.Context, dgst digest.Digest) (bool, *
 
 	return logFound(false, layers, nil)
 }
+
+// BlobCount returns the number of distinct blobs referenced by the image
+// stream's images.
+func (is *imageStream) BlobCount(ctx context.Context) (int, rerrors.Error) {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return 0, convertImageStreamGetterError(err, fmt.Sprintf("BlobCount: failed to get layers of image stream %s", is.Reference()))
+	}
+	return len(layers.Blobs), nil
+}
+
+// HasBlobs is like HasBlob, but it checks several blobs at once. The image
+// stream layers are retrieved only once. The first result maps each of the
+// given digests to whether it is referenced in the image stream. The second
+// result maps each found digest to an image that references it, or that is
+// the digest itself if it is a manifest. Digests whose image cannot be
+// retrieved are found, but have no image.
+func (is *imageStream) HasBlobs(ctx context.Context, dgsts []digest.Digest) (map[digest.Digest]bool, map[digest.Digest]*imageapiv1.Image, rerrors.Error) {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return nil, nil, convertImageStreamGetterError(err, fmt.Sprintf("HasBlobs: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	// Go through the images in a stable order, so that a blob shared by
+	// several images always gets the same image.
+	names := make([]string, 0, len(layers.Images))
+	for name := range layers.Images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	owners := make(map[string]string)
+	for _, name := range names {
+		refs := layers.Images[name]
+		if refs.ImageMissing {
+			continue
+		}
+		for _, layer := range refs.Layers {
+			if _, ok := owners[layer]; !ok {
+				owners[layer] = name
+			}
+		}
+		if refs.Config != nil {
+			if _, ok := owners[*refs.Config]; !ok {
+				owners[*refs.Config] = name
+			}
+		}
+	}
+
+	found := make(map[digest.Digest]bool, len(dgsts))
+	images := make(map[digest.Digest]*imageapiv1.Image)
+	fetched := make(map[string]*imageapiv1.Image)
+	for _, dgst := range dgsts {
+		_, isBlob := layers.Blobs[dgst.String()]
+		refs, isManifest := layers.Images[dgst.String()]
+		found[dgst] = isBlob || isManifest
+
+		owner, ok := owners[dgst.String()]
+		if isManifest && !refs.ImageMissing {
+			owner, ok = dgst.String(), true
+		}
+		if !ok {
+			continue
+		}
+
+		image, seen := fetched[owner]
+		if !seen {
+			image, err = is.imageClient.Get(ctx, digest.Digest(owner))
+			if err != nil {
+				dcontext.GetLogger(ctx).Debugf("HasBlobs: failed to get image %s of image stream %s: %v", owner, is.Reference(), err)
+				image = nil
+			}
+			fetched[owner] = image
+		}
+		if image != nil {
+			images[dgst] = image
+		}
+	}
+
+	return found, images, nil
+}
+
+// UniqueBlobSize returns the total size of the distinct blobs referenced by
+// the image stream's images. Blobs shared by several images are counted once.
+// Blobs without a known size are not counted.
+func (is *imageStream) UniqueBlobSize(ctx context.Context) (int64, rerrors.Error) {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return 0, convertImageStreamGetterError(err, fmt.Sprintf("UniqueBlobSize: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	var size int64
+	for _, blob := range layers.Blobs {
+		if blob.LayerSize != nil {
+			size += *blob.LayerSize
+		}
+	}
+
+	return size, nil
+}
+
+// ShouldPullthroughBlob returns true if the blob is referenced in the image
+// stream and can be served from local storage. Otherwise it returns the best
+// remote repository to pull the blob through from, as ordered by
+// IdentifyCandidateRepositories. The repositories of the latest tag events
+// are preferred over the older ones. If there is no remote repository, the
+// returned spec is nil.
+func (is *imageStream) ShouldPullthroughBlob(ctx context.Context, dgst digest.Digest) (bool, *ImagePullthroughSpec, rerrors.Error) {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return false, nil, convertImageStreamGetterError(err, fmt.Sprintf("ShouldPullthroughBlob: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	_, isBlob := layers.Blobs[dgst.String()]
+	_, isManifest := layers.Images[dgst.String()]
+	if isBlob || isManifest {
+		return true, nil, nil
+	}
+
+	for _, primary := range []bool{true, false} {
+		repositoryCandidates, search, err := is.IdentifyCandidateRepositories(ctx, primary)
+		if err != nil {
+			return false, nil, err
+		}
+		if len(repositoryCandidates) > 0 {
+			spec := search[repositoryCandidates[0]]
+			return false, &spec, nil
+		}
+	}
+
+	return false, nil, nil
+}
+
+// ImageLayerMap returns for each image of the image stream its layer digests
+// ordered from the base layer to the top layer. If the image has a config
+// blob, its digest is appended after the layers. Images that the master API
+// reports as missing and digests that cannot be parsed are skipped.
+func (is *imageStream) ImageLayerMap(ctx context.Context) (map[digest.Digest][]digest.Digest, rerrors.Error) {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("ImageLayerMap: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	result := make(map[digest.Digest][]digest.Digest, len(layers.Images))
+	for name, image := range layers.Images {
+		if image.ImageMissing {
+			continue
+		}
+		imageDigest, err := digest.Parse(name)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", name, err)
+			continue
+		}
+
+		blobs := make([]digest.Digest, 0, len(image.Layers)+1)
+		for _, layer := range image.Layers {
+			blobs = append(blobs, digest.Digest(layer))
+		}
+		if image.Config != nil {
+			blobs = append(blobs, digest.Digest(*image.Config))
+		}
+		result[imageDigest] = blobs
+	}
+
+	return result, nil
+}
+
+// SharedLayers returns the layers the images a and b have in common, in the
+// order they appear in a. Config blobs are not considered. If any of the
+// images is not referenced by the image stream, an error with the code
+// ErrImageStreamImageNotFoundCode is returned.
+func (is *imageStream) SharedLayers(ctx context.Context, a, b digest.Digest) ([]digest.Digest, rerrors.Error) {
+	layers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("SharedLayers: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	imageA, ok := layers.Images[a.String()]
+	if !ok {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("SharedLayers: image %s not found in image stream %s", a.String(), is.Reference()),
+			nil,
+		)
+	}
+	imageB, ok := layers.Images[b.String()]
+	if !ok {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("SharedLayers: image %s not found in image stream %s", b.String(), is.Reference()),
+			nil,
+		)
+	}
+
+	inB := make(map[string]bool, len(imageB.Layers))
+	for _, layer := range imageB.Layers {
+		inB[layer] = true
+	}
+
+	shared := []digest.Digest{}
+	for _, layer := range imageA.Layers {
+		if inB[layer] {
+			shared = append(shared, digest.Digest(layer))
+			// report each layer once even if a uses it several times
+			delete(inB, layer)
+		}
+	}
+
+	return shared, nil
+}
+
+// SharedLayerCountWith returns how many of the given layers, e.g. the layers
+// of an image in another registry, are already referenced by any image of the
+// image stream. Each layer is counted once.
+func (is *imageStream) SharedLayerCountWith(ctx context.Context, layers []digest.Digest) (int, rerrors.Error) {
+	isLayers, err := is.imageStreamGetter.Layers(ctx)
+	if err != nil {
+		return 0, convertImageStreamGetterError(err, fmt.Sprintf("SharedLayerCountWith: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	counted := make(map[digest.Digest]bool, len(layers))
+	for _, layer := range layers {
+		if _, ok := isLayers.Blobs[layer.String()]; ok {
+			counted[layer] = true
+		}
+	}
+	return len(counted), nil
+}
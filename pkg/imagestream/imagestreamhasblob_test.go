@@ -0,0 +1,110 @@
+package imagestream
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+)
+
+func TestSharedLayers(t *testing.T) {
+	const (
+		imageA = "sha256:00000000000000000000000000000000000000000000000000000000000000a0"
+		imageB = "sha256:00000000000000000000000000000000000000000000000000000000000000b0"
+		base   = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		middle = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		topA   = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+		topB   = "sha256:0000000000000000000000000000000000000000000000000000000000000004"
+		config = "sha256:0000000000000000000000000000000000000000000000000000000000000005"
+	)
+
+	configDigest := config
+	is := newTestImageStream(&imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+	})
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			imageA: {Layers: []string{base, middle, topA}, Config: &configDigest},
+			imageB: {Layers: []string{base, middle, topB}, Config: &configDigest},
+		},
+	}
+
+	ctx := context.Background()
+
+	shared, err := is.SharedLayers(ctx, imageA, imageB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []digest.Digest{base, middle}; !reflect.DeepEqual(shared, expected) {
+		t.Errorf("got shared layers %v, want %v", shared, expected)
+	}
+
+	if _, err := is.SharedLayers(ctx, imageA, config); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+
+	layerMap, err := is.ImageLayerMap(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []digest.Digest{base, middle, topA, config}; !reflect.DeepEqual(layerMap[imageA], expected) {
+		t.Errorf("got blobs %v of image %s, want %v", layerMap[imageA], imageA, expected)
+	}
+}
+
+func TestHasBlobs(t *testing.T) {
+	const (
+		imageA  = "sha256:00000000000000000000000000000000000000000000000000000000000000a0"
+		imageB  = "sha256:00000000000000000000000000000000000000000000000000000000000000b0"
+		missing = "sha256:00000000000000000000000000000000000000000000000000000000000000c0"
+		base    = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		topB    = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+		gone    = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+		unknown = "sha256:0000000000000000000000000000000000000000000000000000000000000004"
+	)
+
+	is := newTestImageStream(&imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+	})
+	is.imageClient = fakeImageGetter{
+		imageA: {ObjectMeta: metav1.ObjectMeta{Name: imageA}},
+		imageB: {ObjectMeta: metav1.ObjectMeta{Name: imageB}},
+	}
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Blobs: map[string]imageapiv1.ImageLayerData{
+			base: {},
+			topB: {},
+			gone: {},
+		},
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			imageA:  {Layers: []string{base}},
+			imageB:  {Layers: []string{base, topB}},
+			missing: {Layers: []string{gone}, ImageMissing: true},
+		},
+	}
+
+	found, images, err := is.HasBlobs(context.Background(), []digest.Digest{base, topB, gone, imageB, unknown})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFound := map[digest.Digest]bool{base: true, topB: true, gone: true, imageB: true, unknown: false}
+	if !reflect.DeepEqual(found, expectedFound) {
+		t.Errorf("got found blobs %v, want %v", found, expectedFound)
+	}
+
+	expectedImages := map[digest.Digest]string{base: imageA, topB: imageB, imageB: imageB}
+	if len(images) != len(expectedImages) {
+		t.Errorf("got images for %d blobs, want %d", len(images), len(expectedImages))
+	}
+	for dgst, expected := range expectedImages {
+		if image := images[dgst]; image == nil || image.Name != expected {
+			t.Errorf("got image %v for blob %s, want %s", image, dgst, expected)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package imagestream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/opencontainers/go-digest"
+
+	dockerapiv10 "github.com/openshift/api/image/docker10"
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+	"github.com/openshift/image-registry/pkg/origin-common/util"
+)
+
+// getImages fetches the images with the given digests. At most
+// imageFetchConcurrency images are fetched concurrently. Images that no longer
+// exist are logged and omitted from the result. Any other error stops the
+// dispatching of further fetches and is returned, as is the error of the
+// context if it is cancelled.
+func (is *imageStream) getImages(ctx context.Context, dgsts []digest.Digest) (map[digest.Digest]*imageapiv1.Image, rerrors.Error) {
+	concurrency := is.imageFetchConcurrency
+	if concurrency < 1 {
+		concurrency = defaultImageFetchConcurrency
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		images   = make(map[digest.Digest]*imageapiv1.Image, len(dgsts))
+		sem      = make(chan struct{}, concurrency)
+		firstErr rerrors.Error
+	)
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+dispatch:
+	for _, dgst := range dgsts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+		if ctx.Err() != nil || failed() {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(dgst digest.Digest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			image, err := is.getImage(ctx, dgst)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				images[dgst] = image
+			case err.Code() == ErrImageStreamImageNotFoundCode:
+				dcontext.GetLogger(ctx).Warnf("getImages: skipping image %s: %v", dgst.String(), err)
+			case firstErr == nil:
+				firstErr = err
+			}
+		}(dgst)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, rerrors.NewError(
+			ErrImageStreamUnknownErrorCode,
+			fmt.Sprintf("getImages: stopped fetching the images of image stream %s", is.Reference()),
+			err,
+		)
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return images, nil
+}
+
+// imageDigests returns the distinct digests of all images referenced by the
+// image stream's tag histories.
+func imageDigests(ctx context.Context, stream *imageapiv1.ImageStream) []digest.Digest {
+	seen := make(map[digest.Digest]bool)
+	var dgsts []digest.Digest
+	for _, history := range stream.Status.Tags {
+		for _, item := range history.Items {
+			dgst, err := digest.Parse(item.Image)
+			if err != nil {
+				dcontext.GetLogger(ctx).Errorf("bad digest %s: %v", item.Image, err)
+				continue
+			}
+			if seen[dgst] {
+				continue
+			}
+			seen[dgst] = true
+			dgsts = append(dgsts, dgst)
+		}
+	}
+	return dgsts
+}
+
+// ImagesWithLabel returns the digests of the images in the image stream whose
+// config has the label key set to value.
+//
+// Every distinct image of the image stream has to be fetched, so this method
+// makes one API call per image that is not cached yet. Images that no longer
+// exist are skipped, other errors are returned.
+func (is *imageStream) ImagesWithLabel(ctx context.Context, key, value string) ([]digest.Digest, rerrors.Error) {
+	stream, err := is.imageStreamGetter.Get(ctx)
+	if err != nil {
+		return nil, convertImageStreamGetterError(err, fmt.Sprintf("ImagesWithLabel: failed to get image stream %s", is.Reference()))
+	}
+
+	dgsts := imageDigests(ctx, stream)
+	images, rErr := is.getImages(ctx, dgsts)
+	if rErr != nil {
+		return nil, rErr
+	}
+
+	result := []digest.Digest{}
+	for _, dgst := range dgsts {
+		image, ok := images[dgst]
+		if !ok {
+			continue
+		}
+		meta, ok := image.DockerImageMetadata.Object.(*dockerapiv10.DockerImage)
+		if !ok || meta.Config == nil {
+			continue
+		}
+		if v, ok := meta.Config.Labels[key]; ok && v == value {
+			result = append(result, dgst)
+		}
+	}
+
+	return result, nil
+}
+
+// AvailablePlatforms returns the sub-manifests of the manifest list the tag
+// points to. Each sub-manifest describes the platform it is built for. If
+// the tag points to a single manifest, an error with the code
+// ErrImageStreamNotManifestListCode is returned.
+func (is *imageStream) AvailablePlatforms(ctx context.Context, tag string) ([]imageapiv1.ImageManifest, rerrors.Error) {
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("AvailablePlatforms: failed to get image stream %s", is.Reference()))
+	}
+
+	tagEvent := util.LatestTaggedImage(stream, tag)
+	if tagEvent == nil {
+		return nil, rerrors.NewError(
+			ErrImageStreamImageNotFoundCode,
+			fmt.Sprintf("AvailablePlatforms: unable to find tag %s in image stream %s", tag, is.Reference()),
+			nil,
+		)
+	}
+
+	dgst, err := digest.Parse(tagEvent.Image)
+	if err != nil {
+		return nil, rerrors.NewError(
+			ErrImageStreamUnknownErrorCode,
+			fmt.Sprintf("AvailablePlatforms: bad digest %s of tag %s in image stream %s", tagEvent.Image, tag, is.Reference()),
+			err,
+		)
+	}
+
+	image, rErr := is.getImage(ctx, dgst)
+	if rErr != nil {
+		return nil, rErr
+	}
+
+	if len(image.DockerImageManifests) == 0 {
+		return nil, rerrors.NewError(
+			ErrImageStreamNotManifestListCode,
+			fmt.Sprintf("AvailablePlatforms: tag %s in image stream %s points to a single manifest %s", tag, is.Reference(), dgst.String()),
+			nil,
+		)
+	}
+
+	return image.DockerImageManifests, nil
+}
+
+// imagePlatformConfig holds the platform fields of an image config.
+type imagePlatformConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant"`
+}
+
+// ImagePlatform returns the operating system, the architecture and the
+// architecture variant of the image with the given digest. They are read from
+// the image config. Images without a config, e.g. schema 1 images, only
+// provide the architecture. If the image is a manifest list, an error with the
+// code ErrImageStreamManifestListCode is returned, AvailablePlatforms should
+// be used instead. The image may be a sub-manifest of a manifest list in the
+// image stream. If the image is not part of the image stream, a not found
+// error is returned.
+func (is *imageStream) ImagePlatform(ctx context.Context, dgst digest.Digest) (os, arch, variant string, rErr rerrors.Error) {
+	image, rErr := is.getMemberImage(ctx, dgst)
+	if rErr != nil {
+		return "", "", "", rErr
+	}
+
+	if len(image.DockerImageManifests) > 0 {
+		return "", "", "", rerrors.NewError(
+			ErrImageStreamManifestListCode,
+			fmt.Sprintf("ImagePlatform: image %s in image stream %s is a manifest list", dgst.String(), is.Reference()),
+			nil,
+		)
+	}
+
+	if len(image.DockerImageConfig) > 0 {
+		var config imagePlatformConfig
+		if err := json.Unmarshal([]byte(image.DockerImageConfig), &config); err != nil {
+			return "", "", "", rerrors.NewError(
+				ErrImageStreamUnknownErrorCode,
+				fmt.Sprintf("ImagePlatform: unable to parse the config of image %s in image stream %s", dgst.String(), is.Reference()),
+				err,
+			)
+		}
+		return config.OS, config.Architecture, config.Variant, nil
+	}
+
+	if meta, ok := image.DockerImageMetadata.Object.(*dockerapiv10.DockerImage); ok {
+		return "", meta.Architecture, "", nil
+	}
+	return "", "", "", nil
+}
+
+// ManifestBytes returns the manifest of the image with the given digest and
+// its media type. The image has to belong to the image stream, but it may be
+// a sub-manifest of a manifest list in the image stream. If the image does
+// not carry its manifest, an error with the code
+// ErrImageStreamManifestUnavailableCode is returned.
+func (is *imageStream) ManifestBytes(ctx context.Context, dgst digest.Digest) ([]byte, string, rerrors.Error) {
+	image, err := is.getMemberImage(ctx, dgst)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(image.DockerImageManifest) == 0 {
+		return nil, "", rerrors.NewError(
+			ErrImageStreamManifestUnavailableCode,
+			fmt.Sprintf("ManifestBytes: image %s in image stream %s has no manifest", dgst.String(), is.Reference()),
+			nil,
+		)
+	}
+
+	return []byte(image.DockerImageManifest), image.DockerImageManifestMediaType, nil
+}
+
+// LayerInfo describes a layer of an image.
+type LayerInfo struct {
+	Digest    digest.Digest
+	Size      int64
+	MediaType string
+}
+
+// ImageLineage describes the blobs an image is built from.
+type ImageLineage struct {
+	// ConfigDigest is the digest of the image config blob. It is empty for
+	// images without a separate config blob.
+	ConfigDigest digest.Digest
+	// Layers are ordered from the base layer to the top layer.
+	Layers []LayerInfo
+}
+
+// ImageLineage returns the config and the layers of the image with the given
+// digest. The layer sizes and media types recorded in the image stream layers
+// take precedence over the ones of the image. If the image is not part of the
+// image stream, a not found error is returned.
+func (is *imageStream) ImageLineage(ctx context.Context, dgst digest.Digest) (*ImageLineage, rerrors.Error) {
+	layers, rErr := is.imageStreamGetter.Layers(ctx)
+	if rErr != nil {
+		return nil, convertImageStreamGetterError(rErr, fmt.Sprintf("ImageLineage: failed to get layers of image stream %s", is.Reference()))
+	}
+
+	refs, ok := layers.Images[dgst.String()]
+	if !ok {
+		if _, rErr := is.resolveImageID(ctx, dgst); rErr != nil {
+			return nil, rErr
+		}
+	}
+
+	image, rErr := is.getImage(ctx, dgst)
+	if rErr != nil {
+		return nil, rErr
+	}
+
+	lineage := &ImageLineage{}
+	if refs.Config != nil {
+		lineage.ConfigDigest = digest.Digest(*refs.Config)
+	} else if meta, ok := image.DockerImageMetadata.Object.(*dockerapiv10.DockerImage); ok {
+		// the ID of schema 2 images is the config digest, schema 1 images
+		// have no config blob and their ID is not a digest
+		if configDigest, err := digest.Parse(meta.ID); err == nil {
+			lineage.ConfigDigest = configDigest
+		}
+	}
+
+	for _, layer := range image.DockerImageLayers {
+		info := LayerInfo{
+			Digest:    digest.Digest(layer.Name),
+			Size:      layer.LayerSize,
+			MediaType: layer.MediaType,
+		}
+		if blob, ok := layers.Blobs[layer.Name]; ok {
+			if blob.LayerSize != nil {
+				info.Size = *blob.LayerSize
+			}
+			if len(blob.MediaType) != 0 {
+				info.MediaType = blob.MediaType
+			}
+		}
+		lineage.Layers = append(lineage.Layers, info)
+	}
+
+	return lineage, nil
+}
+
+// signatureTag returns the tag under which cosign stores the signatures of
+// the image with the given digest, e.g. sha256-<hex>.sig.
+func signatureTag(dgst digest.Digest) string {
+	return strings.Replace(dgst.String(), ":", "-", 1) + ".sig"
+}
+
+// HasSignature returns true if the image with the given digest is signed.
+// The image is signed if the image stream has a cosign signature tag for the
+// digest or if the image carries signatures. The image stream has no
+// referrers index, so artifacts attached by other means are not detected.
+// The image may be a sub-manifest of a manifest list in the image stream. If
+// the image is not part of the image stream, a not found error is returned.
+func (is *imageStream) HasSignature(ctx context.Context, dgst digest.Digest) (bool, rerrors.Error) {
+	// A signature tag alone does not make the image part of the image stream.
+	image, rErr := is.getMemberImage(ctx, dgst)
+	if rErr != nil {
+		return false, rErr
+	}
+
+	stream, rErr := is.imageStreamGetter.Get(ctx)
+	if rErr != nil {
+		return false, convertImageStreamGetterError(rErr, fmt.Sprintf("HasSignature: failed to get image stream %s", is.Reference()))
+	}
+
+	if util.LatestTaggedImage(stream, signatureTag(dgst)) != nil {
+		return true, nil
+	}
+	return len(image.Signatures) > 0 || len(image.DockerImageSignatures) > 0, nil
+}
@@ -0,0 +1,451 @@
+package imagestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dockerapiv10 "github.com/openshift/api/image/docker10"
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+	"github.com/openshift/image-registry/pkg/testutil"
+)
+
+func newTestImageWithLabels(dgst digest.Digest, labels map[string]string) *imageapiv1.Image {
+	image := &imageapiv1.Image{
+		ObjectMeta: metav1.ObjectMeta{Name: dgst.String()},
+	}
+	image.DockerImageMetadata.Object = &dockerapiv10.DockerImage{
+		Config: &dockerapiv10.DockerConfig{Labels: labels},
+	}
+	return image
+}
+
+func TestImagesWithLabel(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		acme1 = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		other = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+		acme2 = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000003")
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag:   "latest",
+					Items: []imageapiv1.TagEvent{{Image: acme1.String()}, {Image: other.String()}},
+				},
+				{
+					Tag:   "stable",
+					Items: []imageapiv1.TagEvent{{Image: acme1.String()}, {Image: acme2.String()}},
+				},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		acme1: newTestImageWithLabels(acme1, map[string]string{"vendor": "acme"}),
+		other: newTestImageWithLabels(other, map[string]string{"vendor": "other"}),
+		acme2: newTestImageWithLabels(acme2, map[string]string{"vendor": "acme"}),
+	}
+
+	dgsts, err := is.ImagesWithLabel(ctx, "vendor", "acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []digest.Digest{acme1, acme2}; !reflect.DeepEqual(dgsts, expected) {
+		t.Errorf("got %v, want %v", dgsts, expected)
+	}
+
+	dgsts, err = is.ImagesWithLabel(ctx, "vendor", "nobody")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dgsts == nil || len(dgsts) != 0 {
+		t.Errorf("got %#v, want an empty slice", dgsts)
+	}
+}
+
+// concurrencyImageGetter records the maximum number of concurrent Get calls.
+type concurrencyImageGetter struct {
+	images fakeImageGetter
+
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (ig *concurrencyImageGetter) Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	ig.mu.Lock()
+	ig.current++
+	if ig.current > ig.max {
+		ig.max = ig.current
+	}
+	ig.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	ig.mu.Lock()
+	ig.current--
+	ig.mu.Unlock()
+
+	return ig.images.Get(ctx, dgst)
+}
+
+func TestResolveAllTagsConcurrency(t *testing.T) {
+	const (
+		tags        = 50
+		concurrency = 3
+	)
+
+	imageGetter := &concurrencyImageGetter{images: fakeImageGetter{}}
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+	}
+	for i := 0; i < tags; i++ {
+		dgst := digest.FromString(fmt.Sprintf("image %d", i))
+		imageGetter.images[dgst] = &imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: dgst.String()}}
+		stream.Status.Tags = append(stream.Status.Tags, imageapiv1.NamedTagEventList{
+			Tag:   fmt.Sprintf("tag%d", i),
+			Items: []imageapiv1.TagEvent{{Image: dgst.String()}},
+		})
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = imageGetter
+	WithImageFetchConcurrency(concurrency)(is)
+
+	images, err := is.ResolveAllTags(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != tags {
+		t.Errorf("got %d images, want %d", len(images), tags)
+	}
+	if imageGetter.max > concurrency {
+		t.Errorf("got %d concurrent image fetches, want at most %d", imageGetter.max, concurrency)
+	}
+	if imageGetter.max < 2 {
+		t.Errorf("got %d concurrent image fetches, want images to be fetched concurrently", imageGetter.max)
+	}
+}
+
+// failingImageGetter returns err for the digests in fail and counts the calls.
+type failingImageGetter struct {
+	images fakeImageGetter
+	fail   map[digest.Digest]rerrors.Error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (ig *failingImageGetter) Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	ig.mu.Lock()
+	ig.calls++
+	ig.mu.Unlock()
+
+	if err, ok := ig.fail[dgst]; ok {
+		return nil, err
+	}
+	return ig.images.Get(ctx, dgst)
+}
+
+func TestResolveAllTagsErrors(t *testing.T) {
+	var (
+		present   = digest.FromString("present")
+		deleted   = digest.FromString("deleted")
+		forbidden = digest.FromString("forbidden")
+	)
+
+	newStream := func(dgsts ...digest.Digest) *imageapiv1.ImageStream {
+		stream := &imageapiv1.ImageStream{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		}
+		for i, dgst := range dgsts {
+			stream.Status.Tags = append(stream.Status.Tags, imageapiv1.NamedTagEventList{
+				Tag:   fmt.Sprintf("tag%d", i),
+				Items: []imageapiv1.TagEvent{{Image: dgst.String()}},
+			})
+		}
+		return stream
+	}
+	newImageGetter := func() *failingImageGetter {
+		return &failingImageGetter{
+			images: fakeImageGetter{
+				present: {ObjectMeta: metav1.ObjectMeta{Name: present.String()}},
+			},
+			fail: map[digest.Digest]rerrors.Error{
+				forbidden: rerrors.NewError(ErrImageGetterForbiddenCode, forbidden.String(), kerrors.NewForbidden(imageapiv1.Resource("images"), forbidden.String(), fmt.Errorf("denied"))),
+			},
+		}
+	}
+
+	t.Run("deleted images are skipped", func(t *testing.T) {
+		is := newTestImageStream(newStream(present, deleted))
+		is.imageClient = newImageGetter()
+
+		images, err := is.ResolveAllTags(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(images) != 1 || images["tag0"] == nil {
+			t.Errorf("got images %v, want only the image of tag0", images)
+		}
+	})
+
+	t.Run("other errors are returned", func(t *testing.T) {
+		is := newTestImageStream(newStream(present, forbidden))
+		is.imageClient = newImageGetter()
+
+		if _, err := is.ResolveAllTags(context.Background()); err == nil || err.Code() != ErrImageStreamUnknownErrorCode {
+			t.Errorf("got error %v, want code %s", err, ErrImageStreamUnknownErrorCode)
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		imageGetter := newImageGetter()
+		is := newTestImageStream(newStream(present, present, present))
+		is.imageClient = imageGetter
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := is.ResolveAllTags(ctx); err == nil || !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want the context to be cancelled", err)
+		}
+		if imageGetter.calls != 0 {
+			t.Errorf("got %d image fetches, want none after the context is cancelled", imageGetter.calls)
+		}
+	})
+}
+
+func TestHasSignature(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		cosigned  = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		signature = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+		signed    = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000003")
+		unsigned  = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000004")
+		missing   = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000005")
+		list      = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000006")
+		member    = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000007")
+		stray     = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000008")
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag:   "latest",
+					Items: []imageapiv1.TagEvent{{Image: cosigned.String()}, {Image: signed.String()}, {Image: unsigned.String()}},
+				},
+				{
+					Tag:   "multiarch",
+					Items: []imageapiv1.TagEvent{{Image: list.String(), DockerImageReference: "docker.io/library/busybox@" + list.String()}},
+				},
+				{
+					Tag:   "sha256-0000000000000000000000000000000000000000000000000000000000000001.sig",
+					Items: []imageapiv1.TagEvent{{Image: signature.String()}},
+				},
+				{
+					Tag:   "sha256-0000000000000000000000000000000000000000000000000000000000000008.sig",
+					Items: []imageapiv1.TagEvent{{Image: signature.String()}},
+				},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		cosigned: {ObjectMeta: metav1.ObjectMeta{Name: cosigned.String()}},
+		signed: {
+			ObjectMeta: metav1.ObjectMeta{Name: signed.String()},
+			Signatures: []imageapiv1.ImageSignature{{ObjectMeta: metav1.ObjectMeta{Name: signed.String() + "@sig"}}},
+		},
+		unsigned: {ObjectMeta: metav1.ObjectMeta{Name: unsigned.String()}},
+		list:     {ObjectMeta: metav1.ObjectMeta{Name: list.String()}},
+		member: {
+			ObjectMeta: metav1.ObjectMeta{Name: member.String()},
+			Signatures: []imageapiv1.ImageSignature{{ObjectMeta: metav1.ObjectMeta{Name: member.String() + "@sig"}}},
+		},
+	}
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			list.String(): {Manifests: []string{member.String()}},
+		},
+	}
+
+	for _, tc := range []struct {
+		dgst     digest.Digest
+		expected bool
+	}{
+		{dgst: cosigned, expected: true},
+		{dgst: signed, expected: true},
+		{dgst: unsigned, expected: false},
+		{dgst: list, expected: false},
+		{dgst: member, expected: true},
+	} {
+		ok, err := is.HasSignature(ctx, tc.dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.dgst, err)
+		}
+		if ok != tc.expected {
+			t.Errorf("%s: got %t, want %t", tc.dgst, ok, tc.expected)
+		}
+	}
+
+	for _, dgst := range []digest.Digest{missing, stray} {
+		if _, err := is.HasSignature(ctx, dgst); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+			t.Errorf("%s: got error %v, want code %s", dgst, err, ErrImageStreamImageNotFoundCode)
+		}
+	}
+}
+
+func TestImagePlatform(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		withConfig   = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		schema1      = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+		manifestList = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000003")
+		member       = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000004")
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag: "latest",
+					Items: []imageapiv1.TagEvent{
+						{Image: withConfig.String()},
+						{Image: schema1.String()},
+						{Image: manifestList.String(), DockerImageReference: "docker.io/library/busybox@" + manifestList.String()},
+					},
+				},
+			},
+		},
+	}
+
+	schema1Image := &imageapiv1.Image{ObjectMeta: metav1.ObjectMeta{Name: schema1.String()}}
+	schema1Image.DockerImageMetadata.Object = &dockerapiv10.DockerImage{Architecture: "amd64"}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		withConfig: {
+			ObjectMeta:        metav1.ObjectMeta{Name: withConfig.String()},
+			DockerImageConfig: `{"architecture":"arm64","os":"linux","variant":"v8"}`,
+		},
+		schema1: schema1Image,
+		manifestList: {
+			ObjectMeta:           metav1.ObjectMeta{Name: manifestList.String()},
+			DockerImageManifests: []imageapiv1.ImageManifest{{Digest: member.String()}},
+		},
+		member: {
+			ObjectMeta:        metav1.ObjectMeta{Name: member.String()},
+			DockerImageConfig: `{"architecture":"ppc64le","os":"linux"}`,
+		},
+	}
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			manifestList.String(): {Manifests: []string{member.String()}},
+		},
+	}
+
+	os, arch, variant, err := is.ImagePlatform(ctx, withConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if os != "linux" || arch != "arm64" || variant != "v8" {
+		t.Errorf("got %s/%s/%s, want linux/arm64/v8", os, arch, variant)
+	}
+
+	os, arch, variant, err = is.ImagePlatform(ctx, schema1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if os != "" || arch != "amd64" || variant != "" {
+		t.Errorf("got %s/%s/%s, want /amd64/", os, arch, variant)
+	}
+
+	if _, _, _, err := is.ImagePlatform(ctx, manifestList); err == nil || err.Code() != ErrImageStreamManifestListCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamManifestListCode)
+	}
+
+	os, arch, variant, err = is.ImagePlatform(ctx, member)
+	if err != nil {
+		t.Fatalf("manifest list member: unexpected error: %v", err)
+	}
+	if os != "linux" || arch != "ppc64le" || variant != "" {
+		t.Errorf("manifest list member: got %s/%s/%s, want linux/ppc64le/", os, arch, variant)
+	}
+}
+
+func TestManifestBytes(t *testing.T) {
+	ctx := testutil.WithTestLogger(context.Background(), t)
+
+	const (
+		manifestList = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+		member       = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000002")
+		missing      = digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000003")
+	)
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{
+					Tag:   "latest",
+					Items: []imageapiv1.TagEvent{{Image: manifestList.String(), DockerImageReference: "docker.io/library/busybox@" + manifestList.String()}},
+				},
+			},
+		},
+	}
+
+	is := newTestImageStream(stream)
+	is.imageClient = fakeImageGetter{
+		manifestList: {ObjectMeta: metav1.ObjectMeta{Name: manifestList.String()}},
+		member: {
+			ObjectMeta:                   metav1.ObjectMeta{Name: member.String()},
+			DockerImageManifest:          `{"schemaVersion":2}`,
+			DockerImageManifestMediaType: "application/vnd.oci.image.manifest.v1+json",
+		},
+	}
+	is.imageStreamGetter.(*cachedImageStreamGetter).cachedImageStreamLayers = &imageapiv1.ImageStreamLayers{
+		Images: map[string]imageapiv1.ImageBlobReferences{
+			manifestList.String(): {Manifests: []string{member.String()}},
+		},
+	}
+
+	manifest, mediaType, err := is.ManifestBytes(ctx, member)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(manifest) != `{"schemaVersion":2}` || mediaType != "application/vnd.oci.image.manifest.v1+json" {
+		t.Errorf("got manifest %s of type %s, want the manifest of the member", manifest, mediaType)
+	}
+
+	if _, _, err := is.ManifestBytes(ctx, manifestList); err == nil || err.Code() != ErrImageStreamManifestUnavailableCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamManifestUnavailableCode)
+	}
+
+	if _, _, err := is.ManifestBytes(ctx, missing); err == nil || err.Code() != ErrImageStreamImageNotFoundCode {
+		t.Errorf("got error %v, want code %s", err, ErrImageStreamImageNotFoundCode)
+	}
+}
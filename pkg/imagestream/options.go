@@ -0,0 +1,261 @@
+package imagestream
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	"github.com/openshift/image-registry/pkg/kubernetes-common/credentialprovider"
+	"github.com/openshift/library-go/pkg/image/reference"
+)
+
+// defaultMaxManifestListDepth is the default number of parent manifest lists
+// resolveUpstreamRef walks through. It allows for a manifest list nested in
+// another manifest list.
+const defaultMaxManifestListDepth = 2
+
+// defaultImageFetchConcurrency is the default maximum number of images
+// fetched concurrently by methods that need to fetch many images.
+const defaultImageFetchConcurrency = 8
+
+// Names of the caches reported to a CacheObserver.
+const (
+	CacheImageStream       = "imagestream"
+	CacheImageStreamLayers = "imagestreamlayers"
+	CacheImage             = "image"
+)
+
+// CacheObserver is notified whenever one of the cached getters used by an
+// ImageStream serves a request from its cache (a hit) or has to fetch the
+// object from the master API (a miss). cache is one of CacheImageStream,
+// CacheImageStreamLayers and CacheImage. The methods may be called
+// concurrently.
+type CacheObserver interface {
+	CacheHit(cache string)
+	CacheMiss(cache string)
+}
+
+// Option configures an ImageStream created by New.
+type Option func(*imageStream)
+
+// WithMaxManifestListDepth sets the maximum number of parent manifest lists
+// that are walked through when an upstream reference of a sub-manifest is
+// resolved. Values lower than 1 are ignored.
+func WithMaxManifestListDepth(depth int) Option {
+	return func(is *imageStream) {
+		if depth > 0 {
+			is.maxManifestListDepth = depth
+		}
+	}
+}
+
+// WithImageFetchConcurrency sets the maximum number of images that are
+// fetched concurrently by methods that need to fetch many images, such as
+// ResolveAllTags. Values lower than 1 are ignored.
+func WithImageFetchConcurrency(n int) Option {
+	return func(is *imageStream) {
+		if n > 0 {
+			is.imageFetchConcurrency = n
+		}
+	}
+}
+
+// WithProtectedTags makes CreateImageStreamMapping refuse to move the given
+// tags once they point to an image. The restriction can be lifted for a
+// single request using WithProtectedTagOverride.
+func WithProtectedTags(tags ...string) Option {
+	return func(is *imageStream) {
+		if is.protectedTags == nil {
+			is.protectedTags = make(map[string]bool)
+		}
+		for _, tag := range tags {
+			is.protectedTags[tag] = true
+		}
+	}
+}
+
+// WithMalformedDigestCallback sets a function that is called by Tags for each
+// tag whose latest history entry has a digest that cannot be parsed. It
+// allows to monitor image streams with corrupted histories. The entries are
+// skipped and logged regardless of the callback.
+func WithMalformedDigestCallback(callback func(tag, value string)) Option {
+	return func(is *imageStream) {
+		is.onMalformedDigest = callback
+	}
+}
+
+// WithStrictDigestParsing makes Tags fail with an error with the code
+// ErrImageStreamInvalidDigestCode on the first tag whose latest history entry
+// has a digest that cannot be parsed. By default such tags are skipped, so a
+// single corrupted entry does not make the whole repository unavailable.
+// Failing loudly surfaces the corruption, but clients cannot list the tags of
+// the repository until it is fixed.
+func WithStrictDigestParsing() Option {
+	return func(is *imageStream) {
+		is.strictDigestParsing = true
+	}
+}
+
+// WithPreferPublic makes IdentifyCandidateRepositories and the methods built
+// on it rank the public repository of the image stream above all other
+// candidates, including the internal repository returned by
+// IdentifyCandidateRepositoriesLocalFirst. The public repository is marked
+// LocalFirst. It is meant for setups in which the public route is the
+// canonical way to reach the integrated registry.
+func WithPreferPublic() Option {
+	return func(is *imageStream) {
+		is.preferPublic = true
+	}
+}
+
+// WithMirrorResolver sets a function that maps image digests to mirrors of
+// the images, e.g. based on the cluster's image digest mirror configuration.
+// IdentifyCandidateRepositories asks it about every image it considers and
+// puts the returned mirrors in front of the other candidates. The mirrors are
+// expected to be secure.
+func WithMirrorResolver(resolver func(digest.Digest) (reference.DockerImageReference, bool)) Option {
+	return func(is *imageStream) {
+		is.mirrorResolver = resolver
+	}
+}
+
+// CandidateRanker orders the pullthrough candidates of an image stream. It
+// gets the candidates in the default order and returns them in the order in
+// which they should be tried. Candidates it omits are not tried at all.
+type CandidateRanker func(candidates []ImagePullthroughSpec) []ImagePullthroughSpec
+
+// WithCandidateRanker sets a function that reorders the repositories returned
+// by IdentifyCandidateRepositories, e.g. to prefer repositories in the same
+// region. It is applied after the mirrors have been added.
+func WithCandidateRanker(ranker CandidateRanker) Option {
+	return func(is *imageStream) {
+		is.candidateRanker = ranker
+	}
+}
+
+// WithImageTransform sets a function that is applied to the images returned
+// by GetImageOfImageStream, e.g. to strip labels that should not be served.
+// The function is called after the image's DockerImageReference has been
+// modified and it gets a deep copy of the image, so it may modify its argument
+// without affecting cached images. The image it returns is handed to the
+// caller. Without a transform the images are returned as they are.
+func WithImageTransform(transform func(*imageapiv1.Image) *imageapiv1.Image) Option {
+	return func(is *imageStream) {
+		is.imageTransform = transform
+	}
+}
+
+// WithAutoProvisionedAnnotation controls whether image streams created by
+// CreateImageStreamMapping carry AutoProvisionedAnnotation. It is enabled by
+// default.
+func WithAutoProvisionedAnnotation(enabled bool) Option {
+	return func(is *imageStream) {
+		is.annotateAutoProvisioned = enabled
+	}
+}
+
+// WithIncludeEmptyTags makes Tags also return the tags that have no images
+// yet, e.g. because their import is pending. Such tags are mapped to the
+// empty digest. By default they are omitted.
+func WithIncludeEmptyTags() Option {
+	return func(is *imageStream) {
+		is.includeEmptyTags = true
+	}
+}
+
+// WithDigestAliases sets alternative digests for images. The keys are the
+// digests clients may ask for, the values are the digests under which the
+// images are recorded, e.g. the sha256 digests of content requested by its
+// sha512 digest. ResolveImageID and the image lookups consult the aliases
+// when nothing is found for the requested digest.
+//
+// Aliasing is opt-in, no aliases are used by default.
+func WithDigestAliases(aliases map[digest.Digest]digest.Digest) Option {
+	return func(is *imageStream) {
+		is.digestAliases = aliases
+	}
+}
+
+// WithTracer makes the image stream start spans using tracer around the
+// retrieval of image streams, their layers and images, and around the
+// creation of image stream mappings. Without a tracer no spans are created.
+// Use oteltracer.New to create the spans with OpenTelemetry.
+func WithTracer(tracer Tracer) Option {
+	return func(is *imageStream) {
+		is.tracer = tracer
+	}
+}
+
+// WithAuditSink makes CreateImageStreamMapping pass a record of each
+// successfully created image stream mapping to sink, including the mappings
+// created after the image stream has been auto provisioned.
+func WithAuditSink(sink AuditSink) Option {
+	return func(is *imageStream) {
+		is.auditSink = sink
+	}
+}
+
+// WithRedactedErrors makes the methods used to serve registry clients, such
+// as GetImageOfImageStream and CreateImageStreamMapping, return errors with
+// generic messages that do not reveal image stream names or digests. The
+// error codes are preserved and the detailed errors are logged.
+func WithRedactedErrors() Option {
+	return func(is *imageStream) {
+		is.redactErrors = true
+	}
+}
+
+// WithCacheObserver makes the cached image and image stream getters report
+// cache hits and misses to observer. It has no effect on getters passed to
+// NewWithGetters that were not created by NewCachedImageGetter or
+// NewCachedImageStreamGetter. The getters are not modified, so a getter
+// shared by several ImageStreams reports to the observer of the ImageStream
+// that uses it.
+func WithCacheObserver(observer CacheObserver) Option {
+	return func(is *imageStream) {
+		is.cacheObserver = observer
+	}
+}
+
+// WithClock makes the ImageStream use now instead of time.Now in all
+// time-dependent logic: the time of created image stream mappings, the age of
+// cached limit range lists and the durations that are logged. It allows to
+// write deterministic tests. A nil function is ignored.
+func WithClock(now func() time.Time) Option {
+	return func(is *imageStream) {
+		if now != nil {
+			is.clock = now
+		}
+	}
+}
+
+// ImageStreamGetterFactory returns a getter for the image stream
+// namespace/name.
+type ImageStreamGetterFactory func(namespace, name string) ImageStreamGetter
+
+// WithImageStreamGetterFactory makes the methods that follow references into
+// other image streams, such as ResolveCrossStreamTag and ValidateTagSource,
+// use factory to get those image streams. Without a factory the getters are
+// created from the master API client, and an ImageStream created by
+// NewWithGetters without a client cannot reach other image streams. A nil
+// factory is ignored.
+func WithImageStreamGetterFactory(factory ImageStreamGetterFactory) Option {
+	return func(is *imageStream) {
+		if factory != nil {
+			is.getterFactory = factory
+		}
+	}
+}
+
+// WithDefaultKeyring makes IdentifyCandidateRepositoriesByAuth consider the
+// repositories for which keyring provides credentials authenticated, in
+// addition to the ones covered by the image stream's secrets. It should be
+// the keyring the pull layer uses besides the secrets, i.e. the installation
+// credentials.
+func WithDefaultKeyring(keyring credentialprovider.DockerKeyring) Option {
+	return func(is *imageStream) {
+		is.defaultKeyring = keyring
+	}
+}
@@ -0,0 +1,60 @@
+//go:build otel
+
+// Package oteltracer adapts go.opentelemetry.io/otel to imagestream.Tracer, so
+// that the spans started by ImageStream join the traces of the registry.
+//
+// The package is built only with the otel build tag, as otel is not vendored
+// yet.
+package oteltracer
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+	"github.com/openshift/image-registry/pkg/imagestream"
+)
+
+const instrumentationName = "github.com/openshift/image-registry/pkg/imagestream"
+
+// errorCodeKey is the attribute that holds the code of the error that ended
+// an operation.
+const errorCodeKey = attribute.Key("imagestream.error.code")
+
+// New returns a Tracer that starts spans using the global tracer provider.
+// Until a tracer provider is configured, the spans are no-ops.
+func New() imagestream.Tracer {
+	return NewWithTracer(otel.Tracer(instrumentationName))
+}
+
+// NewWithTracer returns a Tracer that starts spans using tracer.
+func NewWithTracer(tracer trace.Tracer) imagestream.Tracer {
+	return &otelTracer{tracer: tracer}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t *otelTracer) Start(ctx context.Context, operation string) (context.Context, imagestream.Span) {
+	ctx, span := t.tracer.Start(ctx, operation)
+	return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) RecordError(err rerrors.Error) {
+	s.span.SetAttributes(errorCodeKey.String(err.Code()))
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Code())
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
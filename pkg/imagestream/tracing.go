@@ -0,0 +1,68 @@
+package imagestream
+
+import (
+	"context"
+
+	"github.com/opencontainers/go-digest"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+)
+
+// Tracer starts spans around the master API calls made by ImageStream.
+//
+// Spans are started with go.opentelemetry.io/otel by the adapter in package
+// oteltracer. The adapter is built only with the otel build tag, as otel is
+// not vendored yet; ImageStream itself does not depend on otel.
+type Tracer interface {
+	Start(ctx context.Context, operation string) (context.Context, Span)
+}
+
+// Span is a span started by Tracer.
+type Span interface {
+	// RecordError records the code of the error that ended the operation.
+	RecordError(err rerrors.Error)
+	End()
+}
+
+// endSpan records err on span, if any, and ends the span.
+func endSpan(span Span, err rerrors.Error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// tracingImageGetter wraps an ImageGetter with spans.
+type tracingImageGetter struct {
+	ImageGetter
+	tracer Tracer
+}
+
+func (g *tracingImageGetter) Get(ctx context.Context, dgst digest.Digest) (*imageapiv1.Image, rerrors.Error) {
+	ctx, span := g.tracer.Start(ctx, "imagestream.getImage")
+	image, err := g.ImageGetter.Get(ctx, dgst)
+	endSpan(span, err)
+	return image, err
+}
+
+// tracingImageStreamGetter wraps an ImageStreamGetter with spans.
+type tracingImageStreamGetter struct {
+	ImageStreamGetter
+	tracer Tracer
+}
+
+func (g *tracingImageStreamGetter) Get(ctx context.Context) (*imageapiv1.ImageStream, rerrors.Error) {
+	ctx, span := g.tracer.Start(ctx, "imagestream.get")
+	is, err := g.ImageStreamGetter.Get(ctx)
+	endSpan(span, err)
+	return is, err
+}
+
+func (g *tracingImageStreamGetter) Layers(ctx context.Context) (*imageapiv1.ImageStreamLayers, rerrors.Error) {
+	ctx, span := g.tracer.Start(ctx, "imagestream.layers")
+	layers, err := g.ImageStreamGetter.Layers(ctx)
+	endSpan(span, err)
+	return layers, err
+}
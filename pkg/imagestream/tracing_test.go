@@ -0,0 +1,68 @@
+package imagestream
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	imageapiv1 "github.com/openshift/api/image/v1"
+
+	rerrors "github.com/openshift/image-registry/pkg/errors"
+)
+
+type recordedSpan struct {
+	operation string
+	code      string
+	ended     bool
+}
+
+type fakeTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, operation string) (context.Context, Span) {
+	span := &recordedSpan{operation: operation}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordedSpan) RecordError(err rerrors.Error) {
+	s.code = err.Code()
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	ctx := context.Background()
+	dgst := digest.Digest("sha256:0000000000000000000000000000000000000000000000000000000000000001")
+
+	stream := &imageapiv1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "is"},
+		Status: imageapiv1.ImageStreamStatus{
+			Tags: []imageapiv1.NamedTagEventList{
+				{Tag: "latest", Items: []imageapiv1.TagEvent{{Image: dgst.String()}}},
+			},
+		},
+	}
+
+	tracer := &fakeTracer{}
+	is := NewWithGetters(ctx, "ns", "is", nil, fakeImageGetter{}, &cachedImageStreamGetter{cachedImageStream: stream, cachedImageStreamLayers: &imageapiv1.ImageStreamLayers{}}, WithTracer(tracer))
+
+	if _, err := is.GetImageOfImageStream(ctx, dgst); err == nil {
+		t.Fatal("got no error for a missing image")
+	}
+
+	expected := []*recordedSpan{
+		{operation: "imagestream.get", ended: true},
+		{operation: "imagestream.getImage", code: ErrImageGetterNotFoundCode, ended: true},
+	}
+	if !reflect.DeepEqual(tracer.spans[:2], expected) {
+		t.Errorf("got spans %+v, want %+v", tracer.spans[:2], expected)
+	}
+}